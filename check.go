@@ -0,0 +1,256 @@
+package pingd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pinggg/pingd/httping"
+	"github.com/pinggg/pingd/ping"
+)
+
+// Result attribute names set by the checkers in this package
+const (
+	AttrTTL       = "ttl"        // TTL of the ICMP echo reply
+	AttrStatus    = "status"     // HTTP status code
+	AttrTLSExpiry = "tls_expiry" // expiry of the server certificate, RFC 3339
+	AttrAddrs     = "addrs"      // comma separated addresses resolved by a DNS check
+	AttrLoss      = "loss"       // percentage of ICMP echoes lost
+	AttrMinRTT    = "rtt_min"    // fastest ICMP echo reply
+	AttrMaxRTT    = "rtt_max"    // slowest ICMP echo reply
+	AttrMdev      = "rtt_mdev"   // standard deviation of the ICMP echo replies
+	AttrJitter    = "jitter"     // mean difference between consecutive ICMP echo replies
+	AttrMTUFrom   = "mtu_from"   // router which told the path MTU, if any
+	AttrProbes    = "probes"     // echoes sent to find the path MTU
+)
+
+// Result is the outcome of a single check of a host
+type Result struct {
+	Up    bool
+	RTT   time.Duration     // round-trip time, 0 if unknown
+	Loss  float64           // percentage of probes lost, for checks sending several
+	MTU   int               // path MTU, for checks finding it, 0 if unknown
+	Err   error             // why the host is down
+	Attrs map[string]string // checker specific details
+}
+
+// Checker checks whether a host is up. The check must
+// give up and return as soon as ctx is done.
+type Checker interface {
+	Check(ctx context.Context, host string) Result
+}
+
+// CheckerFunc adapts a function to the Checker interface
+type CheckerFunc func(ctx context.Context, host string) Result
+
+// Check calls f(ctx, host)
+func (f CheckerFunc) Check(ctx context.Context, host string) Result {
+	return f(ctx, host)
+}
+
+// PingChecker adapts a PingFunc to the Checker interface measuring
+// the RTT around it. When ctx is done the Check returns ctx.Err()
+// and the ping is left running until it returns by itself.
+func PingChecker(ping PingFunc) Checker {
+	return CheckerFunc(func(ctx context.Context, host string) Result {
+		resCh := make(chan Result, 1)
+		go func() {
+			start := time.Now()
+			up, err := ping(host)
+			resCh <- Result{Up: up, RTT: time.Since(start), Err: err}
+		}()
+
+		select {
+		case r := <-resCh:
+			if r.Up {
+				r.Err = nil
+			} else {
+				r.RTT = 0
+			}
+			return r
+		case <-ctx.Done():
+			return Result{Err: ctx.Err()}
+		}
+	})
+}
+
+// CheckICMP checks the host with an ICMP echo, see ping.PingContext,
+// with the payload size, IP header and source of the host settings.
+// The host may have an icmp:// scheme, IPv6 addresses in brackets then.
+func CheckICMP(ctx context.Context, host string) Result {
+	stats, err := ping.PingStats(ctx, icmpHost(host), probeOptions(ctx, ping.Options{Count: 1}))
+	if err != nil {
+		return Result{Err: err}
+	}
+
+	return Result{
+		Up:    true,
+		RTT:   stats.Avg,
+		Attrs: map[string]string{AttrTTL: strconv.Itoa(stats.TTL)},
+	}
+}
+
+// probeOptions returns opts with the ICMP echo
+// settings of the host checked set, see HostSettings
+func probeOptions(ctx context.Context, opts ping.Options) ping.Options {
+	s, ok := HostSettings(ctx)
+	if !ok {
+		return opts
+	}
+
+	if s.PayloadSize > 0 {
+		opts.Size = s.PayloadSize
+	}
+	if s.TTL > 0 {
+		opts.TTL = s.TTL
+	}
+	if s.TOS > 0 {
+		opts.TOS = s.TOS
+	}
+	if s.DontFragment {
+		opts.DontFragment = true
+	}
+	if s.Source != "" {
+		opts.Source = s.Source
+	}
+	return opts
+}
+
+// ICMPChecker returns a checker sending opts.Count ICMP echoes per
+// check, see ping.PingStats. The host is down when the loss is over
+// downLoss percent, so a few lost echoes just show on Result.Loss.
+// The RTT is the average of the replies. The host settings override
+// the payload size, IP header and source of opts. The host may have
+// an icmp:// scheme.
+func ICMPChecker(opts ping.Options, downLoss float64) Checker {
+	return CheckerFunc(func(ctx context.Context, host string) Result {
+		stats, err := ping.PingStats(ctx, icmpHost(host), probeOptions(ctx, opts))
+		if err != nil {
+			return Result{Loss: 100, Err: err}
+		}
+
+		r := Result{
+			Up:   stats.Loss <= downLoss,
+			RTT:  stats.Avg,
+			Loss: stats.Loss,
+			Attrs: map[string]string{
+				AttrTTL:    strconv.Itoa(stats.TTL),
+				AttrLoss:   strconv.FormatFloat(stats.Loss, 'g', -1, 64),
+				AttrMinRTT: stats.Min.String(),
+				AttrMaxRTT: stats.Max.String(),
+				AttrMdev:   stats.Mdev.String(),
+				AttrJitter: stats.Jitter.String(),
+			},
+		}
+		if !r.Up {
+			r.Err = fmt.Errorf("loss %g%% over %g%%", stats.Loss, downLoss)
+		}
+
+		return r
+	})
+}
+
+// PathMTUChecker returns a checker finding the path MTU to the host up to
+// max bytes, see ping.PingMTU, and opts.Count echoes per size. The host is
+// down if it doesn't reply to the smallest packets, degraded if the MTU is
+// under Settings.MinMTU, and its changes are notified with MTU events.
+// The RTT is the one of the last reply. Each size lost on a black hole
+// takes a whole echo timeout, a host Timeout too short for the search
+// reports the largest size found so far. The host may have an icmp://
+// scheme.
+func PathMTUChecker(opts ping.Options, max int) Checker {
+	return CheckerFunc(func(ctx context.Context, host string) Result {
+		mtu, err := ping.PingMTU(ctx, icmpHost(host), max, probeOptions(ctx, opts))
+		if err != nil {
+			return Result{Err: err}
+		}
+
+		r := Result{
+			Up:    true,
+			RTT:   mtu.RTT,
+			MTU:   mtu.Size,
+			Attrs: map[string]string{AttrProbes: strconv.Itoa(mtu.Probes)},
+		}
+		if mtu.From != nil {
+			r.Attrs[AttrMTUFrom] = mtu.From.String()
+		}
+
+		return r
+	})
+}
+
+// icmpHost returns the host to ping without scheme nor brackets
+func icmpHost(host string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(trimScheme(host), "["), "]")
+}
+
+// CheckHTTP checks the URL with a HEAD request expecting a 200,
+// see httping.PingContext
+func CheckHTTP(ctx context.Context, url string) Result {
+	resp, err := httping.PingContext(ctx, url)
+
+	r := Result{Up: err == nil, RTT: resp.RTT, Err: err}
+	if resp.Status != 0 {
+		r.Attrs = map[string]string{AttrStatus: strconv.Itoa(resp.Status)}
+		if !resp.TLSExpiry.IsZero() {
+			r.Attrs[AttrTLSExpiry] = resp.TLSExpiry.Format(time.RFC3339)
+		}
+	}
+
+	return r
+}
+
+// CheckTCP checks that a connection can be opened to
+// tcp://host:port, the RTT is the time to connect
+func CheckTCP(ctx context.Context, host string) Result {
+	addr := strings.TrimSuffix(trimScheme(host), "/")
+
+	var d net.Dialer
+	start := time.Now()
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return Result{Err: err}
+	}
+	rtt := time.Since(start)
+	c.Close()
+
+	return Result{Up: true, RTT: rtt}
+}
+
+// CheckDNS checks that dns://server/name resolves, the server is
+// optional, dns:///name or dns://name use the system resolver
+func CheckDNS(ctx context.Context, host string) Result {
+	server, name := "", trimScheme(host)
+	if i := strings.Index(name, "/"); i >= 0 {
+		server, name = name[:i], name[i+1:]
+	}
+
+	resolver := net.DefaultResolver
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	start := time.Now()
+	addrs, err := resolver.LookupHost(ctx, name)
+	if err != nil {
+		return Result{Err: err}
+	}
+
+	return Result{
+		Up:    true,
+		RTT:   time.Since(start),
+		Attrs: map[string]string{AttrAddrs: strings.Join(addrs, ",")},
+	}
+}
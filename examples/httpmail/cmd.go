@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
@@ -12,14 +13,17 @@ import (
 	"github.com/pinggg/pingd"
 	"github.com/pinggg/pingd/io/http"
 	"github.com/pinggg/pingd/io/mail"
+	"github.com/pinggg/pingd/io/prometheus"
 	"github.com/pinggg/pingd/io/std"
 	"github.com/pinggg/pingd/ping"
 )
 
 // See flags
 var (
-	emailAddr  string
-	listenAddr string
+	emailAddr    string
+	listenAddr   string
+	silencesFile string
+	stateDir     string
 
 	interval  time.Duration
 	failLimit int
@@ -28,6 +32,8 @@ var (
 func main() {
 	flag.StringVar(&emailAddr, "email", "me@example.org", "email recipient for notificiations")
 	flag.StringVar(&listenAddr, "listen", ":7700", "webserver listen address")
+	flag.StringVar(&silencesFile, "silences", "silences.json", "file to keep the silences in")
+	flag.StringVar(&stateDir, "state", "state", "directory to keep the hosts state in across restarts")
 	flag.IntVar(&failLimit, "failLimit", 4, "number failed ping attempts in a row to consider host down")
 	flag.DurationVar(&interval, "interval", 5*time.Second, "seconds between each ping")
 	flag.DurationVar(&ping.TimeOut, "timeOut", 5*time.Second, "seconds for single ping timeout")
@@ -36,21 +42,47 @@ func main() {
 	// read non flag arguments as hosts to start monitoring
 	hosts := flag.Args()
 
+	silences, err := pingd.NewSilences(silencesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := pingd.NewStore(stateDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
 	var pool = &pingd.Pool{
-		Ping:      ping.Ping,
+		Checker: pingd.NewRegistry(),
+		// path MTU of the hosts with check=pmtu, two echoes per size as a lost one looks like a black hole
+		Checks:    map[string]pingd.Checker{"pmtu": pingd.PathMTUChecker(ping.Options{Count: 2}, 1500)},
+		Tracer:    pingd.ICMPTracer(ping.Options{}, 0), // mail the route to the hosts going down
 		Interval:  interval,
 		FailLimit: failLimit,
-		Receive:   http.NewReceiverFunc(listenAddr),          // start/stop commands via HTTP
-		Notify:    mail.NewNotifierFunc(emailAddr, sendMail), // notify up/down via email
-		Load:      std.NewLoaderFunc(hosts),                  // load initial hosts from command line
+		Load:      std.NewLoaderFunc(hosts), // load initial hosts from command line
+		Silences:  silences,
+		Store:     store,
+		Sinks: []pingd.Sink{
+			// notify up/down via email, a slow mail server only keeps the latest event of each host
+			{Name: "mail", Notify: mail.NewNotifierFunc(emailAddr, sendMail), Overflow: pingd.Coalesce},
+			// and log them
+			{Name: "log", Notify: std.NewNotifierFunc(), Overflow: pingd.DropOldest},
+		},
 	}
 
-	pool.Start()
+	// start/stop commands, silences and metrics via HTTP
+	pool.Receive = http.NewReceiverFunc(listenAddr,
+		http.WithSilences(silences),
+		http.WithHandler("/metrics", prometheus.NewHandler(pool)),
+	)
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	// Run until interrupted, then let in-flight pings
+	// finish and deliver their events before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	<-c // Exit on interrupt
+	pool.Run(ctx)
 }
 
 // Replace this localhost version with your appropriate mail function
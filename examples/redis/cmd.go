@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 	"os/signal"
@@ -28,18 +29,19 @@ func main() {
 	flag.Parse()
 
 	var pool = &pingd.Pool{
-		Ping:      ping.Ping,
+		Checker:   pingd.NewRegistry(),
+		Tracer:    pingd.ICMPTracer(ping.Options{}, 0), // publish the last hop of the hosts going down
 		Interval:  interval,
 		FailLimit: failLimit,
 		Receive:   redis.NewReceiverFunc(redisAddr, redisDB, "start", "stop", "hostlist"),
-		Notify:    redis.NewNotifierFunc(redisAddr, redisDB, "up", "down"),
+		Notify:    redis.NewDegradedNotifierFunc(redisAddr, redisDB, "up", "down", "degraded"),
 		Load:      redis.NewLoaderFunc(redisAddr, redisDB, "hostlist"),
 	}
 
-	pool.Start()
+	// Run until interrupted, then let in-flight pings
+	// finish and deliver their events before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-
-	<-c // Exit on interrupt
+	pool.Run(ctx)
 }
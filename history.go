@@ -0,0 +1,208 @@
+package pingd
+
+import (
+	"time"
+)
+
+// DefaultHistorySize is the number of check results kept
+// per host by a pool that doesn't set HistorySize
+const DefaultHistorySize = 100
+
+// maxTransitions is the number of transitions kept per host,
+// older ones are forgotten and not counted on the uptime.
+const maxTransitions = 1000
+
+// CheckRecord is the result of a past check
+type CheckRecord struct {
+	Time time.Time
+	Up   bool
+	RTT  time.Duration
+	Err  error
+}
+
+// Transition is a past change of a host between up and down
+type Transition struct {
+	Time time.Time
+	Down bool // status after the change
+}
+
+// History is a snapshot of the recent checks and transitions of a host,
+// the status is known from Start, when it was StartDown, to End.
+type History struct {
+	Checks      []CheckRecord // oldest first
+	Transitions []Transition  // oldest first
+	Start       time.Time
+	StartDown   bool
+	End         time.Time
+}
+
+// Availability sums up the history of a host, times before
+// it was monitored are not counted on the uptime.
+type Availability struct {
+	Hour  float64       // uptime percentage over the last hour
+	Day   float64       // uptime percentage over the last 24 hours
+	Week  float64       // uptime percentage over the last 7 days
+	Month float64       // uptime percentage over the last 30 days
+	MTTR  time.Duration // mean time to recover, 0 if it never recovered
+	MTBF  time.Duration // mean time between failures, 0 if it never failed
+}
+
+// Availability computes the uptime, MTTR and MTBF
+func (h History) Availability() Availability {
+	return Availability{
+		Hour:  h.Uptime(time.Hour),
+		Day:   h.Uptime(24 * time.Hour),
+		Week:  h.Uptime(7 * 24 * time.Hour),
+		Month: h.Uptime(30 * 24 * time.Hour),
+		MTTR:  h.MTTR(),
+		MTBF:  h.MTBF(),
+	}
+}
+
+// Uptime returns the percentage of time up over the window before End
+func (h History) Uptime(window time.Duration) float64 {
+	from := h.End.Add(-window)
+	if from.Before(h.Start) {
+		from = h.Start
+	}
+
+	total := h.End.Sub(from)
+	if total <= 0 {
+		if h.StartDown {
+			return 0
+		}
+		return 100
+	}
+
+	return 100 * float64(total-h.downtime(from, h.End)) / float64(total)
+}
+
+// MTTR returns the average time down until recovering,
+// an outage still going on is not counted
+func (h History) MTTR() time.Duration {
+	var total time.Duration
+	recoveries := 0
+	down, at := h.StartDown, h.Start
+	for _, t := range h.Transitions {
+		if down && !t.Down {
+			total += t.Time.Sub(at)
+			recoveries++
+		}
+		down, at = t.Down, t.Time
+	}
+	if recoveries == 0 {
+		return 0
+	}
+
+	return total / time.Duration(recoveries)
+}
+
+// MTBF returns the average time up until failing
+func (h History) MTBF() time.Duration {
+	failures := 0
+	for _, t := range h.Transitions {
+		if t.Down {
+			failures++
+		}
+	}
+	if failures == 0 {
+		return 0
+	}
+
+	up := h.End.Sub(h.Start) - h.downtime(h.Start, h.End)
+	return up / time.Duration(failures)
+}
+
+// downtime returns the time down between from and to
+func (h History) downtime(from, to time.Time) time.Duration {
+	var total time.Duration
+	down, at := h.StartDown, h.Start
+	for _, t := range h.Transitions {
+		if down {
+			total += overlap(at, t.Time, from, to)
+		}
+		down, at = t.Down, t.Time
+	}
+	if down {
+		total += overlap(at, to, from, to)
+	}
+
+	return total
+}
+
+// overlap returns the length of the intersection of [a1, a2) and [b1, b2)
+func overlap(a1, a2, b1, b2 time.Time) time.Duration {
+	if a1.Before(b1) {
+		a1 = b1
+	}
+	if a2.After(b2) {
+		a2 = b2
+	}
+	if a2.Before(a1) {
+		return 0
+	}
+	return a2.Sub(a1)
+}
+
+// history keeps the last checks on a ring buffer and
+// the transitions, it's protected by the monitor lock.
+type history struct {
+	checks      []CheckRecord
+	next        int // position of the next check on the ring
+	full        bool
+	transitions []Transition
+	start       time.Time
+	startDown   bool
+	down        bool
+}
+
+func newHistory(size int, down bool) *history {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+
+	return &history{
+		checks:    make([]CheckRecord, size),
+		start:     time.Now(),
+		startDown: down,
+		down:      down,
+	}
+}
+
+// add records a check which left the host down or not
+func (h *history) add(c CheckRecord, down bool) {
+	h.checks[h.next] = c
+	h.next = (h.next + 1) % len(h.checks)
+	if h.next == 0 {
+		h.full = true
+	}
+
+	if down == h.down {
+		return
+	}
+	h.down = down
+
+	if len(h.transitions) == maxTransitions {
+		// the status is only known since the oldest transition kept
+		h.start, h.startDown = h.transitions[0].Time, h.transitions[0].Down
+		h.transitions = h.transitions[1:]
+	}
+	h.transitions = append(h.transitions, Transition{Time: c.Time, Down: down})
+}
+
+// snapshot returns a copy of the history until now
+func (h *history) snapshot() History {
+	var checks []CheckRecord
+	if h.full {
+		checks = append(checks, h.checks[h.next:]...)
+	}
+	checks = append(checks, h.checks[:h.next]...)
+
+	return History{
+		Checks:      checks,
+		Transitions: append([]Transition(nil), h.transitions...),
+		Start:       h.start,
+		StartDown:   h.startDown,
+		End:         time.Now(),
+	}
+}
@@ -0,0 +1,104 @@
+package pingd
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestHistoryRing(t *testing.T) {
+	h := newHistory(3, false)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		h.add(CheckRecord{Time: start.Add(time.Duration(i) * time.Second), Up: i != 3}, i == 3)
+	}
+
+	s := h.snapshot()
+	if len(s.Checks) != 3 || !s.Checks[0].Time.Equal(start.Add(2*time.Second)) || s.Checks[1].Up {
+		t.Errorf("Got checks: %+v, expected the last 3 oldest first", s.Checks)
+	}
+	if len(s.Transitions) != 2 || !s.Transitions[0].Down || s.Transitions[1].Down {
+		t.Errorf("Got transitions: %+v, expected down and up", s.Transitions)
+	}
+
+	for i := 0; i < maxTransitions; i++ {
+		h.add(CheckRecord{Time: start.Add(time.Duration(10+i) * time.Second)}, i%2 == 0)
+	}
+	s = h.snapshot()
+	// the 2 first transitions are forgotten, the status is known since the second one
+	if len(s.Transitions) != maxTransitions || !s.Start.Equal(start.Add(4*time.Second)) || s.StartDown {
+		t.Errorf("Got start: %s down: %t, expected the oldest transition forgotten", s.Start, s.StartDown)
+	}
+}
+
+// TestAvailability tests a host monitored for 10h,
+// down for 1h and then for 15m in the last hour
+func TestAvailability(t *testing.T) {
+	end := time.Now()
+	h := History{
+		Start: end.Add(-10 * time.Hour),
+		Transitions: []Transition{
+			{Time: end.Add(-5 * time.Hour), Down: true},
+			{Time: end.Add(-4 * time.Hour)},
+			{Time: end.Add(-30 * time.Minute), Down: true},
+			{Time: end.Add(-15 * time.Minute)},
+		},
+		End: end,
+	}
+
+	expected := Availability{
+		Hour:  75,
+		Day:   87.5,
+		Week:  87.5,
+		Month: 87.5,
+		MTTR:  37*time.Minute + 30*time.Second,
+		MTBF:  4*time.Hour + 22*time.Minute + 30*time.Second,
+	}
+	if a := h.Availability(); a != expected {
+		t.Errorf("Got availability: %+v, expected: %+v", a, expected)
+	}
+
+	h.Transitions = h.Transitions[:3]
+	if a := h.Availability(); a.Hour != 50 || a.MTTR != time.Hour {
+		t.Errorf("Got availability: %+v, expected 50%% in the last hour still down", a)
+	}
+}
+
+func TestPoolHistory(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	checked := make(chan struct{}, 1)
+	pool := &Pool{
+		Interval:    time.Millisecond,
+		FailLimit:   1,
+		HistorySize: 2,
+		Checker: CheckerFunc(func(ctx context.Context, host string) Result {
+			select {
+			case checked <- struct{}{}:
+			default:
+			}
+			return Result{Up: true, RTT: time.Millisecond}
+		}),
+	}
+	pool.Start()
+	defer pool.Shutdown(context.Background())
+
+	if _, err := pool.Availability("h1"); err != ErrHostNotFound {
+		t.Errorf("Got error: %v, expected: %v", err, ErrHostNotFound)
+	}
+
+	pool.Add(HostStatus{Host: "h1"})
+	for i := 0; i < 3; i++ {
+		<-checked
+	}
+
+	h, _ := pool.History("h1")
+	if len(h.Checks) != 2 || !h.Checks[1].Up || h.Checks[1].RTT != time.Millisecond {
+		t.Errorf("Got checks: %+v, expected the last 2", h.Checks)
+	}
+	if a, _ := pool.Availability("h1"); a.Month != 100 || a.MTBF != 0 {
+		t.Errorf("Got availability: %+v, expected always up", a)
+	}
+}
@@ -1,6 +1,7 @@
 package httping
 
 import (
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -12,17 +13,41 @@ import (
 // Timeout sets the ping timeout in milliseconds
 var TimeOut = 5 * time.Second
 
+// Response holds the details of a HEAD response
+type Response struct {
+	Status    int           // HTTP status code
+	RTT       time.Duration // time until the response headers arrived
+	TLSExpiry time.Time     // expiry of the server certificate, zero without TLS
+}
+
 // Ping sends a HEAD command to a given URL, returns whether the host answers 200 or not
 func Ping(url string) (up bool, err error) {
+	_, err = PingContext(context.Background(), url)
+	return err == nil, err
+}
+
+// PingContext sends a HEAD command to a given URL and returns the response,
+// the error is not nil if the host doesn't answer 200. It gives up when ctx
+// is done or after TimeOut, whatever happens first.
+func PingContext(ctx context.Context, url string) (Response, error) {
+	var res Response
 	client := http.Client{
 		Timeout: TimeOut,
 	}
 
-	resp, err := client.Head(url)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
-		return false, err
+		return res, err
 	}
 
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return res, err
+	}
+	res.RTT = time.Since(start)
+	res.Status = resp.StatusCode
+
 	// Drain body just in case server misbehaves
 	defer func() {
 		n, _ := io.Copy(ioutil.Discard, resp.Body)
@@ -33,9 +58,13 @@ func Ping(url string) (up bool, err error) {
 		resp.Body.Close()
 	}()
 
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		res.TLSExpiry = resp.TLS.PeerCertificates[0].NotAfter
+	}
+
 	if resp.StatusCode == 200 {
-		return true, nil
+		return res, nil
 	}
 
-	return false, errors.New(resp.Status)
+	return res, errors.New(resp.Status)
 }
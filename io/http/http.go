@@ -1,45 +1,150 @@
 package http
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/pinggg/pingd"
 )
 
+// silencesPath is where the silences are managed
+// instead of a host when the receiver has them
+const silencesPath = "/silences"
+
 type pingHTTP struct {
-	startCh chan<- pingd.HostStatus
-	stopCh  chan<- pingd.HostStatus
+	ctx      context.Context
+	startCh  chan<- pingd.HostStatus
+	stopCh   chan<- pingd.HostStatus
+	silences *pingd.Silences
+	handlers map[string]http.Handler
+}
+
+// Option configures the receiver
+type Option func(*pingHTTP)
+
+// WithSilences manages the silences under /silences:
+// GET lists them, POST adds one taking its fields as query
+// parameters, starting now if start is not given, and
+// DELETE /silences/<id> removes it, eg: POST /silences?host=db*&start=2024-01-01T02:00:00Z&duration=1h&every=24h
+func WithSilences(silences *pingd.Silences) Option {
+	return func(p *pingHTTP) {
+		p.silences = silences
+	}
+}
+
+// WithHandler serves path with handler instead of taking it as a
+// host, eg: WithHandler("/metrics", prometheus.NewHandler(pool))
+func WithHandler(path string, handler http.Handler) Option {
+	return func(p *pingHTTP) {
+		if p.handlers == nil {
+			p.handlers = make(map[string]http.Handler)
+		}
+		p.handlers[path] = handler
+	}
 }
 
-// ServeHTTP handles the incoming start/stop commands via HTTP
+// ServeHTTP handles the incoming start/stop commands via HTTP,
+// start commands take the host settings as query parameters
+// eg: /example.com?interval=10s&failLimit=3
 func (p pingHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := p.handlers[r.URL.Path]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	if p.silences != nil && (r.URL.Path == silencesPath || strings.HasPrefix(r.URL.Path, silencesPath+"/")) {
+		p.serveSilences(w, r)
+		return
+	}
+
 	host := r.URL.Path[1:]
 	if host == "" {
 		fmt.Fprint(w, "missing host on request\n")
+		return
+	}
+
+	h := pingd.HostStatus{Host: host, Down: false}
+	ch, action := p.startCh, "start"
+	if r.Method == "DELETE" {
+		ch, action = p.stopCh, "stop"
+	} else {
+		for name, values := range r.URL.Query() {
+			if err := h.Settings.Set(name, values[0]); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	select {
+	case ch <- h:
+		fmt.Fprintf(w, "%s ping %s\n", action, host)
+	case <-p.ctx.Done():
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
 	}
+}
+
+// serveSilences lists, adds and removes silences
+func (p pingHTTP) serveSilences(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, silencesPath), "/")
+
+	switch {
+	case r.Method == "GET" && id == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.silences.List())
+
+	case r.Method == "POST" && id == "":
+		fields := make(map[string]string)
+		for name, values := range r.URL.Query() {
+			fields[name] = values[0]
+		}
+		silence, err := pingd.ParseSilence(fields)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		silence, err = p.silences.Add(silence)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "add silence %s\n", silence.ID)
+
+	case r.Method == "DELETE" && id != "":
+		if err := p.silences.Remove(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "remove silence %s\n", id)
 
-	switch r.Method {
-	case "DELETE":
-		fmt.Fprintf(w, "stop ping %s\n", host)
-		p.stopCh <- pingd.HostStatus{Host: host, Down: false}
 	default:
-		fmt.Fprintf(w, "start ping %s\n", host)
-		p.startCh <- pingd.HostStatus{Host: host, Down: false}
+		http.Error(w, "unsupported request on silences", http.StatusMethodNotAllowed)
 	}
 }
 
 // NewReceiverFunc returns the functions with sets up the system channels
-// and starts the webserver
-func NewReceiverFunc(listen string) pingd.Receiver {
-	return func(startCh, stopCh chan<- pingd.HostStatus) {
-		var p = &pingHTTP{startCh, stopCh}
+// and starts the webserver, which is shut down when the pool stops
+func NewReceiverFunc(listen string, opts ...Option) pingd.Receiver {
+	return func(ctx context.Context, startCh, stopCh chan<- pingd.HostStatus) {
+		var p = &pingHTTP{ctx: ctx, startCh: startCh, stopCh: stopCh}
+		for _, opt := range opts {
+			opt(p)
+		}
+		srv := &http.Server{Addr: listen, Handler: p}
+
+		go func() {
+			<-ctx.Done()
+			srv.Shutdown(context.Background())
+		}()
+
 		log.Printf("Web server starting on %s", listen)
-		err := http.ListenAndServe(listen, p)
-		if err != nil {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
-
 	}
 }
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pinggg/pingd"
+)
+
+// TestSilences tests adding silences whatever the order of their fields
+func TestSilences(t *testing.T) {
+	silences, _ := pingd.NewSilences("")
+	p := pingHTTP{ctx: context.Background(), silences: silences}
+
+	for _, query := range []string{
+		"duration=1h&every=24h&host=db*&start=2024-01-01T02:00:00Z",
+		"host=db*&start=2024-01-01T02:00:00Z&duration=1h&every=24h",
+		"every=24h&duration=1h&start=2024-01-01T02:00:00Z&host=db*",
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("POST", "/silences?"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("Got status: %d %q for %s, expected: %d", w.Code, w.Body, query, http.StatusOK)
+		}
+	}
+	start, _ := time.Parse(time.RFC3339, "2024-01-01T02:00:00Z")
+	for _, s := range silences.List() {
+		if !s.Start.Equal(start) || s.End.Sub(s.Start) != time.Hour || s.Every != 24*time.Hour {
+			t.Errorf("Got silence: %+v, expected an hour from %s every 24h", s, start)
+		}
+	}
+
+	// without start it starts now
+	before := time.Now().Truncate(time.Second)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("POST", "/silences?duration=1h&host=web1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got status: %d %q, expected: %d", w.Code, w.Body, http.StatusOK)
+	}
+	list := silences.List()
+	if s := list[len(list)-1]; s.Host != "web1" || s.Start.Before(before) || s.End.Sub(s.Start) != time.Hour {
+		t.Errorf("Got silence: %+v, expected an hour from now", s)
+	}
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("POST", "/silences?host=web1&duration=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Got status: %d, expected: %d", w.Code, http.StatusBadRequest)
+	}
+}
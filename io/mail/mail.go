@@ -3,6 +3,7 @@ package mail
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/pinggg/pingd"
 )
@@ -12,19 +13,18 @@ import (
 type Mailer func(recepient string, message string)
 
 // NewNotifierFunc takes a email address and a email sending function
-// and will send emails with every up and down event.
+// and will send emails with every up and down event, the
+// DOWN ones with the route to the host if it was traced.
 func NewNotifierFunc(recepient string, mailerFunc Mailer) pingd.Notifier {
 	return func(notify <-chan pingd.HostStatus) {
-		for {
-			host := <-notify
-			status := "UP"
-			if host.Down {
-				status = "DOWN"
+		for host := range notify {
+			message := fmt.Sprintf("%s at %s", host, host.Time.Format(time.RFC1123))
+			if host.Route != nil {
+				message += "\n\nRoute:\n" + host.Route.String()
 			}
-			message := fmt.Sprintf("host %s is %s", host.Host, status)
 
 			mailerFunc(recepient, message)
-			log.Printf(message)
+			log.Print(message)
 		}
 	}
 }
@@ -0,0 +1,159 @@
+package prometheus
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pinggg/pingd"
+	"github.com/pinggg/pingd/ping"
+)
+
+// metrics writes the metrics of a pool in the Prometheus text format
+type metrics struct {
+	pool *pingd.Pool
+}
+
+// NewHandler returns the handler serving the metrics of pool,
+// it can be mounted on any server, eg: on the io/http receiver
+// with http.WithHandler("/metrics", prometheus.NewHandler(pool))
+func NewHandler(pool *pingd.Pool) http.Handler {
+	return metrics{pool}
+}
+
+// Serve serves the metrics of pool on /metrics at listen until ctx is done
+func Serve(ctx context.Context, listen string, pool *pingd.Pool) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", NewHandler(pool))
+	srv := &http.Server{Addr: listen, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("Metrics server starting on %s", listen)
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (m metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	b := bufio.NewWriter(w)
+	m.write(b, time.Now())
+	b.Flush()
+}
+
+// write writes all the metrics, hosts sorted by name
+func (m metrics) write(w io.Writer, now time.Time) {
+	var statuses []pingd.MonitorStatus
+	for _, host := range m.pool.Hosts() {
+		if status, err := m.pool.Status(host); err == nil {
+			statuses = append(statuses, status)
+		}
+	}
+
+	header(w, "pingd_host_up", "gauge", "Whether the host is up (1) or down (0).")
+	for _, s := range statuses {
+		sample(w, "pingd_host_up", bool01(!s.Down), "host", s.Host)
+	}
+
+	header(w, "pingd_host_state_seconds", "gauge", "Time since the last transition of the host, labeled with its current state.")
+	for _, s := range statuses {
+		sample(w, "pingd_host_state_seconds", now.Sub(s.LastChange).Seconds(), "host", s.Host, "state", s.State.String())
+	}
+
+	header(w, "pingd_host_rtt_seconds", "gauge", "Round-trip time of the last successful check of the host.")
+	for _, s := range statuses {
+		sample(w, "pingd_host_rtt_seconds", s.RTT.Seconds(), "host", s.Host)
+	}
+
+	header(w, "pingd_host_path_mtu_bytes", "gauge", "Path MTU found by the last check of the host, for the path MTU checks.")
+	for _, s := range statuses {
+		if s.MTU > 0 {
+			sample(w, "pingd_host_path_mtu_bytes", float64(s.MTU), "host", s.Host)
+		}
+	}
+
+	header(w, "pingd_host_checks_total", "counter", "Checks of the host by result.")
+	for _, s := range statuses {
+		sample(w, "pingd_host_checks_total", float64(s.ChecksUp), "host", s.Host, "result", "up")
+		sample(w, "pingd_host_checks_total", float64(s.ChecksDown), "host", s.Host, "result", "down")
+	}
+
+	header(w, "pingd_host_transitions_total", "counter", "Status changes of the host.")
+	for _, s := range statuses {
+		sample(w, "pingd_host_transitions_total", float64(s.Transitions), "host", s.Host)
+	}
+
+	header(w, "pingd_monitors", "gauge", "Hosts being monitored.")
+	sample(w, "pingd_monitors", float64(len(statuses)))
+
+	header(w, "pingd_notify_backlog", "gauge", "Events waiting to be queued on the sinks.")
+	sample(w, "pingd_notify_backlog", float64(m.pool.Backlog()))
+
+	sinks := m.pool.SinkStats()
+	header(w, "pingd_sink_queued", "gauge", "Events waiting on the queue of the sink.")
+	for _, s := range sinks {
+		sample(w, "pingd_sink_queued", float64(s.Queued), "sink", s.Name)
+	}
+	header(w, "pingd_sink_events_total", "counter", "Events handled by the sink by outcome.")
+	for _, s := range sinks {
+		sample(w, "pingd_sink_events_total", float64(s.Delivered), "sink", s.Name, "outcome", "delivered")
+		sample(w, "pingd_sink_events_total", float64(s.Dropped), "sink", s.Name, "outcome", "dropped")
+		sample(w, "pingd_sink_events_total", float64(s.Coalesced), "sink", s.Name, "outcome", "coalesced")
+	}
+
+	sched := m.pool.SchedulerStats()
+	header(w, "pingd_checks_in_flight", "gauge", "Checks running.")
+	sample(w, "pingd_checks_in_flight", float64(sched.InFlight))
+	header(w, "pingd_check_workers", "gauge", "Max checks running at once.")
+	sample(w, "pingd_check_workers", float64(sched.Workers))
+	header(w, "pingd_checks_due", "gauge", "Checks past their time waiting for a worker.")
+	sample(w, "pingd_checks_due", float64(sched.Due))
+	header(w, "pingd_check_lag_seconds", "gauge", "Delay behind schedule of the last check started.")
+	sample(w, "pingd_check_lag_seconds", sched.Lag.Seconds())
+
+	icmp := ping.EngineCounters()
+	header(w, "pingd_icmp_messages_total", "counter", "ICMP messages read by the ping engines by outcome.")
+	sample(w, "pingd_icmp_messages_total", float64(icmp.Replies), "outcome", "reply")
+	sample(w, "pingd_icmp_messages_total", float64(icmp.Errors), "outcome", "error")
+	sample(w, "pingd_icmp_messages_total", float64(icmp.Corrupted), "outcome", "corrupted")
+	sample(w, "pingd_icmp_messages_total", float64(icmp.Foreign), "outcome", "foreign")
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a metric value with labels given as name, value pairs
+func sample(w io.Writer, name string, value float64, labels ...string) {
+	fmt.Fprint(w, name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, labels[i]+`="`+escaper.Replace(labels[i+1])+`"`)
+		}
+		fmt.Fprint(w, "{"+strings.Join(pairs, ",")+"}")
+	}
+	fmt.Fprintf(w, " %g\n", value)
+}
+
+// escaper escapes label values as the text format requires
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func bool01(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
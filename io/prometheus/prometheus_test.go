@@ -0,0 +1,116 @@
+package prometheus
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/pinggg/pingd"
+)
+
+// expected is the scrape of a pool with a host up and a host down
+// whose name needs escaping, before any check, with the time in
+// the current state replaced by X as it keeps growing
+const expected = `# HELP pingd_host_up Whether the host is up (1) or down (0).
+# TYPE pingd_host_up gauge
+pingd_host_up{host="10.0.0.1"} 1
+pingd_host_up{host="web\"1\\\nb"} 0
+# HELP pingd_host_state_seconds Time since the last transition of the host, labeled with its current state.
+# TYPE pingd_host_state_seconds gauge
+pingd_host_state_seconds{host="10.0.0.1",state="up"} X
+pingd_host_state_seconds{host="web\"1\\\nb",state="down"} X
+# HELP pingd_host_rtt_seconds Round-trip time of the last successful check of the host.
+# TYPE pingd_host_rtt_seconds gauge
+pingd_host_rtt_seconds{host="10.0.0.1"} 0
+pingd_host_rtt_seconds{host="web\"1\\\nb"} 0
+# HELP pingd_host_path_mtu_bytes Path MTU found by the last check of the host, for the path MTU checks.
+# TYPE pingd_host_path_mtu_bytes gauge
+# HELP pingd_host_checks_total Checks of the host by result.
+# TYPE pingd_host_checks_total counter
+pingd_host_checks_total{host="10.0.0.1",result="up"} 0
+pingd_host_checks_total{host="10.0.0.1",result="down"} 0
+pingd_host_checks_total{host="web\"1\\\nb",result="up"} 0
+pingd_host_checks_total{host="web\"1\\\nb",result="down"} 0
+# HELP pingd_host_transitions_total Status changes of the host.
+# TYPE pingd_host_transitions_total counter
+pingd_host_transitions_total{host="10.0.0.1"} 0
+pingd_host_transitions_total{host="web\"1\\\nb"} 0
+# HELP pingd_monitors Hosts being monitored.
+# TYPE pingd_monitors gauge
+pingd_monitors 2
+# HELP pingd_notify_backlog Events waiting to be queued on the sinks.
+# TYPE pingd_notify_backlog gauge
+pingd_notify_backlog 0
+# HELP pingd_sink_queued Events waiting on the queue of the sink.
+# TYPE pingd_sink_queued gauge
+pingd_sink_queued{sink="log"} 0
+# HELP pingd_sink_events_total Events handled by the sink by outcome.
+# TYPE pingd_sink_events_total counter
+pingd_sink_events_total{sink="log",outcome="delivered"} 0
+pingd_sink_events_total{sink="log",outcome="dropped"} 0
+pingd_sink_events_total{sink="log",outcome="coalesced"} 0
+# HELP pingd_checks_in_flight Checks running.
+# TYPE pingd_checks_in_flight gauge
+pingd_checks_in_flight 0
+# HELP pingd_check_workers Max checks running at once.
+# TYPE pingd_check_workers gauge
+pingd_check_workers 2
+# HELP pingd_checks_due Checks past their time waiting for a worker.
+# TYPE pingd_checks_due gauge
+pingd_checks_due 0
+# HELP pingd_check_lag_seconds Delay behind schedule of the last check started.
+# TYPE pingd_check_lag_seconds gauge
+pingd_check_lag_seconds 0
+# HELP pingd_icmp_messages_total ICMP messages read by the ping engines by outcome.
+# TYPE pingd_icmp_messages_total counter
+pingd_icmp_messages_total{outcome="reply"} 0
+pingd_icmp_messages_total{outcome="error"} 0
+pingd_icmp_messages_total{outcome="corrupted"} 0
+pingd_icmp_messages_total{outcome="foreign"} 0
+`
+
+// TestHandler tests the text exposition of the metrics of a pool
+func TestHandler(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// no check runs within the hour the first ones are spread over
+	pool := &pingd.Pool{
+		Interval:  time.Hour,
+		FailLimit: 1,
+		Checker: pingd.CheckerFunc(func(ctx context.Context, host string) pingd.Result {
+			return pingd.Result{Up: true}
+		}),
+		Workers: 2,
+		Sinks: []pingd.Sink{{Name: "log", Notify: func(ch <-chan pingd.HostStatus) {
+			for range ch {
+			}
+		}}},
+	}
+	pool.Start()
+	defer pool.Shutdown(context.Background())
+	pool.Add(pingd.HostStatus{Host: "10.0.0.1"})
+	pool.Add(pingd.HostStatus{Host: "web\"1\\\nb", Down: true})
+
+	srv := httptest.NewServer(NewHandler(pool))
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Got content type: %q, expected the text format", ct)
+	}
+	text := regexp.MustCompile(`(pingd_host_state_seconds\{.*\}) \d\S*`).ReplaceAllString(string(b), "$1 X")
+	if text != expected {
+		t.Errorf("Got metrics:\n%s\nexpected:\n%s", text, expected)
+	}
+}
@@ -1,6 +1,8 @@
 package redis
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -14,19 +16,55 @@ import (
 )
 
 const (
-	upStatus   = "up"   // Status value for host up
-	downStatus = "down" // Status value for host down
+	upStatus       = "up"       // Status value for host up
+	downStatus     = "down"     // Status value for host down
+	degradedStatus = "degraded" // Status value for host up but slow or lossy
 
 	// when receiving host on the start channel
 	// they can be requested to start as "down"
-	// adding it at the end eg "example.com down"
-	downSuffix = " down"
+	// adding it after the host eg "example.com down"
+	downFlag = "down"
 )
 
+// parseStart parses a start command "<host> [down] [name=value ...]"
+// where the name=value pairs are the host settings, eg:
+// "example.com down interval=10s failLimit=3". The settings
+// part is returned as well so it can be stored.
+func parseStart(command string) (pingd.HostStatus, string, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return pingd.HostStatus{}, "", errors.New("missing host")
+	}
+
+	h := pingd.HostStatus{Host: fields[0]}
+	fields = fields[1:]
+	if len(fields) > 0 && fields[0] == downFlag {
+		h.Down = true
+		fields = fields[1:]
+	}
+
+	err := parseSettings(fields, &h.Settings)
+	return h, strings.Join(fields, " "), err
+}
+
+// parseSettings parses name=value pairs into s
+func parseSettings(fields []string, s *pingd.Settings) error {
+	for _, field := range fields {
+		i := strings.Index(field, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid setting %q", field)
+		}
+		if err := s.Set(field[:i], field[i+1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // NewReceiverFunc returns the function that
 // listens of redis for start/stop commands
 func NewReceiverFunc(redisAddr string, redisDB int, startKey, stopKey, listKey string) pingd.Receiver {
-	return func(startHostCh, stopHostCh chan<- pingd.HostStatus) {
+	return func(ctx context.Context, startHostCh, stopHostCh chan<- pingd.HostStatus) {
 		conPubSub, err := redis.Dial("tcp", redisAddr)
 		if err != nil {
 			log.Panicln(err)
@@ -43,57 +81,94 @@ func NewReceiverFunc(redisAddr string, redisDB int, startKey, stopKey, listKey s
 		connKV.Do("CLIENT", "SETNAME", "receive-"+servername)
 		connKV.Do("SELECT", redisDB)
 
-		psc := redis.PubSubConn{conPubSub}
+		psc := redis.PubSubConn{Conn: conPubSub}
 		psc.Subscribe(startKey, stopKey)
 
+		// closing the connection unblocks psc.Receive on shutdown
+		stopped := make(chan struct{})
+		defer close(stopped)
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-stopped:
+			}
+			conPubSub.Close()
+			connKV.Close()
+		}()
+
 		for {
 			switch n := psc.Receive().(type) {
 			case redis.Message:
 				if n.Channel == startKey {
-					host := string(n.Data)
-					down := false
-					if strings.HasSuffix(host, downSuffix) {
-						down = true
-						host = strings.Replace(host, downSuffix, "", 1)
+					h, settings, err := parseStart(string(n.Data))
+					if err != nil {
+						log.Printf("ERROR %v on start %q\n", err, n.Data)
+						continue
 					}
 
 					// Add to the list of pinged hosts
-					_, err := connKV.Do("SADD", listKey, host)
+					// along with its settings
+					_, err = connKV.Do("SADD", listKey, h.Host)
+					if err == nil {
+						_, err = connKV.Do("SET", "settings-"+h.Host, settings)
+					}
 					if err != nil {
 						log.Panicln(err)
 					}
-					startHostCh <- pingd.HostStatus{Host: host, Down: down}
+					select {
+					case startHostCh <- h:
+					case <-ctx.Done():
+						return
+					}
 
 				} else if n.Channel == stopKey {
 					host := string(n.Data)
 
 					// Remove from the list of pinged hosts
 					_, err := connKV.Do("SREM", listKey, host)
+					if err == nil {
+						_, err = connKV.Do("DEL", "settings-"+host)
+					}
 					if err != nil {
 						log.Panicln(err)
 					}
-					stopHostCh <- pingd.HostStatus{Host: host}
+					select {
+					case stopHostCh <- pingd.HostStatus{Host: host}:
+					case <-ctx.Done():
+						return
+					}
 				}
 
 			case redis.PMessage:
 			case redis.Subscription:
 				log.Println("BOOT Listening to " + n.Channel)
 			case error:
-				log.Printf("error: %v\n", n)
+				if ctx.Err() == nil {
+					log.Printf("error: %v\n", n)
+				}
 				return
 			}
 		}
 	}
 }
 
-// NewNotifierFunc returns the function that
-// publishes on redis the up/down events
+// NewNotifierFunc returns the function that publishes on redis the
+// up/down events, the degraded ones are only stored, see
+// NewDegradedNotifierFunc to publish them too
 func NewNotifierFunc(redisAddr string, redisDB int, upKey, downKey string) pingd.Notifier {
+	return NewDegradedNotifierFunc(redisAddr, redisDB, upKey, downKey, "")
+}
+
+// NewDegradedNotifierFunc returns the function that publishes on redis
+// the up/down events, and the degraded ones on their own channel if
+// degradedKey is set
+func NewDegradedNotifierFunc(redisAddr string, redisDB int, upKey, downKey, degradedKey string) pingd.Notifier {
 	return func(notifyCh <-chan pingd.HostStatus) {
 		conn, err := redis.Dial("tcp", redisAddr)
 		if err != nil {
 			log.Panicln(err)
 		}
+		defer conn.Close()
 
 		servername, _ := os.Hostname()
 		_, err = conn.Do("CLIENT", "SETNAME", "notify-"+servername)
@@ -106,39 +181,105 @@ func NewNotifierFunc(redisAddr string, redisDB int, upKey, downKey string) pingd
 			log.Panicln(err)
 		}
 
-		var h pingd.HostStatus
-		for {
-			select {
-			case h = <-notifyCh:
-				switch h.Down {
-				// DOWN
-				case true:
-					log.Println("DOWN " + h.Host)
-					conn.Send("PUBLISH", downKey, fmt.Sprintf("%s %s", h.Host, h.Reason))
-					conn.Send("SET", "status-"+h.Host, downStatus)
-					conn.Flush()
-					// UP
-				case false:
-					log.Println("UP " + h.Host)
-					conn.Send("PUBLISH", upKey, h.Host)
-					conn.Send("SET", "status-"+h.Host, upStatus)
-					conn.Flush()
+		for h := range notifyCh {
+			log.Println(h.String())
+			switch {
+			// FLAPPING and MTU, only the event is stored
+			case h.Flapping, h.WasMTU != 0:
+			// DOWN
+			case h.Down:
+				message := fmt.Sprintf("%s %s", h.Host, h.Reason)
+				if h.Route != nil {
+					if hop, ok := h.Route.Last(); ok {
+						message += fmt.Sprintf(" (last hop %s)", hop.Addr)
+					}
+				}
+				conn.Send("PUBLISH", downKey, message)
+				conn.Send("SET", "status-"+h.Host, downStatus)
+			// DEGRADED
+			case h.Degraded:
+				if degradedKey != "" {
+					conn.Send("PUBLISH", degradedKey, fmt.Sprintf("%s %s", h.Host, h.Reason))
 				}
+				conn.Send("SET", "status-"+h.Host, degradedStatus)
+			// UP
+			default:
+				conn.Send("PUBLISH", upKey, h.Host)
+				conn.Send("SET", "status-"+h.Host, upStatus)
 			}
+			conn.Send("HMSET", redis.Args{}.Add("event-"+h.Host).AddFlat(newEvent(h))...)
+			conn.Flush()
+		}
+	}
+}
+
+// event is the hash stored at "event-<host>" with
+// the details of the last up/down event of a host
+type event struct {
+	Status   string `redis:"status"`
+	Flapping bool   `redis:"flapping"` // events are held back until the host settles
+	Silenced bool   `redis:"silenced"` // sent when a silence ended, since is its length
+	Reason   string `redis:"reason"`
+	Time     int64  `redis:"time"`     // unix timestamp
+	Previous string `redis:"previous"` // status before the event
+	Since    int64  `redis:"since"`    // seconds in the previous status
+	Failures int    `redis:"failures"`
+	RTT      int64  `redis:"rtt"`      // milliseconds
+	Children string `redis:"children"` // comma separated hosts failing behind this one
+	MTU      int    `redis:"mtu"`      // path MTU, 0 if unknown
+	WasMTU   int    `redis:"wasMtu"`   // path MTU before the change, on MTU events
+	Route    string `redis:"route"`    // on DOWN, the traced hops one per line
+	LastHop  string `redis:"lastHop"`  // on DOWN, the last hop which answered the traceroute
+}
+
+func newEvent(h pingd.HostStatus) *event {
+	e := &event{
+		Status:   upStatus,
+		Previous: upStatus,
+		Time:     h.Time.Unix(),
+		Since:    int64(h.Since / time.Second),
+		Failures: h.Failures,
+		RTT:      int64(h.RTT / time.Millisecond),
+		Flapping: h.Flapping,
+		Silenced: h.Silenced,
+		Children: strings.Join(h.Children, ","),
+		MTU:      h.MTU,
+		WasMTU:   h.WasMTU,
+	}
+	if h.Down {
+		e.Status = downStatus
+	} else if h.Degraded {
+		e.Status = degradedStatus
+	}
+	if h.WasDown {
+		e.Previous = downStatus
+	} else if h.WasDegraded {
+		e.Previous = degradedStatus
+	}
+	if h.Reason != nil {
+		e.Reason = h.Reason.Error()
+	}
+	if h.Route != nil {
+		e.Route = h.Route.String()
+		if hop, ok := h.Route.Last(); ok {
+			e.LastHop = hop.Addr.String()
 		}
 	}
+
+	return e
 }
 
 // NewLoaderFunc returns the function that loads back
 // hosts and last statuses from REDIS in case of reboot
 // send them to the startHostCh channel
 func NewLoaderFunc(redisAddr string, redisDB int, listKey string) pingd.Loader {
-	return func(startHostCh chan<- pingd.HostStatus) {
+	return func(ctx context.Context, startHostCh chan<- pingd.HostStatus) {
 		log.Println("BOOT Loading hosts")
 		conn, err := redis.Dial("tcp", redisAddr)
 		if err != nil {
 			log.Panicln(err)
 		}
+		defer conn.Close()
 
 		servername, _ := os.Hostname()
 		_, err = conn.Do("CLIENT", "SETNAME", "load-"+servername)
@@ -167,8 +308,23 @@ func NewLoaderFunc(redisAddr string, redisDB int, listKey string) pingd.Loader {
 				down = true
 			}
 
+			h := pingd.HostStatus{Host: host, Down: down}
+			settings, err := redis.String(conn.Do("GET", "settings-"+host))
+			if err == nil {
+				err = parseSettings(strings.Fields(settings), &h.Settings)
+			}
+			if err != nil && err != redis.ErrNil {
+				log.Println("ERROR loading settings of " + host + ". Using defaults")
+				h.Settings = pingd.Settings{}
+			}
+
 			// load into process
-			startHostCh <- pingd.HostStatus{Host: host, Down: down}
+			select {
+			case startHostCh <- h:
+			case <-ctx.Done():
+				log.Println("BOOT Loading interrupted")
+				return
+			}
 
 			// slow a bit loading process
 			time.Sleep(time.Millisecond * 10)
@@ -1,6 +1,7 @@
 package std
 
 import (
+	"context"
 	"log"
 
 	"github.com/pinggg/pingd"
@@ -10,9 +11,13 @@ import (
 // function that when called will insert them as Host structs into the
 // start channel at boot time.
 func NewLoaderFunc(hosts []string) pingd.Loader {
-	return func(load chan<- pingd.HostStatus) {
+	return func(ctx context.Context, load chan<- pingd.HostStatus) {
 		for _, host := range hosts {
-			load <- pingd.HostStatus{Host: host, Down: false}
+			select {
+			case load <- pingd.HostStatus{Host: host, Down: false}:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
@@ -20,16 +25,8 @@ func NewLoaderFunc(hosts []string) pingd.Loader {
 // NewNotifierFunc returns a function with just logs up and down events
 func NewNotifierFunc() pingd.Notifier {
 	return func(notifyCh <-chan pingd.HostStatus) {
-		for {
-			select {
-			case h := <-notifyCh:
-				switch h.Down {
-				case true:
-					log.Println("DOWN " + h.Host)
-				case false:
-					log.Println("UP " + h.Host)
-				}
-			}
+		for h := range notifyCh {
+			log.Println(h.String())
 		}
 	}
 }
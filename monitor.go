@@ -1,116 +1,600 @@
 package pingd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"sync"
 	"time"
+
+	"github.com/pinggg/pingd/ping"
 )
 
-// PingFunc is function signature for ping checks
+// PingFunc is function signature for ping checks,
+// see PingChecker to use it as a Checker
 type PingFunc func(host string) (up bool, err error)
 
+// ErrTimeout is the failure reason of pings exceeding Settings.Timeout
+var ErrTimeout = errors.New("ping timeout")
+
+// State is where a monitor is in its up/down cycle. A host goes from Up
+// to Suspect on the first failure and is Down after FailLimit failures
+// in a row, likewise it goes from Down to Recovering on the first success
+// and is Up after RecoverLimit successes in a row. Any opposite result
+// sends Suspect back to Up and Recovering back to Down. A host failing
+// while any of its parents is failing too is Unreachable instead of Down.
+// An Up host is Degraded after DegradeLimit checks in a row over the
+// MaxRTT or MaxLoss thresholds, and Up again after as many under them.
+type State int
+
+// States of a monitor
+const (
+	StateUp State = iota
+	StateSuspect
+	StateDown
+	StateRecovering
+	StateUnreachable
+	StateDegraded
+)
+
+var stateNames = [...]string{"up", "suspect", "down", "recovering", "unreachable", "degraded"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return "unknown"
+	}
+	return stateNames[s]
+}
+
+// Down tells whether the host is considered down in this state,
+// a suspect host is still up and a recovering one still down
+func (s State) Down() bool {
+	return s == StateDown || s == StateRecovering || s == StateUnreachable
+}
+
+// failing tells whether the host failed its last check in this state,
+// a degraded host is slow but still answers so it's not failing
+func (s State) failing() bool {
+	return s == StateSuspect || s.Down()
+}
+
+// dependencies tells a monitor about the hosts it depends on,
+// the ones depending on it and whether it's silenced
+type dependencies interface {
+	// parentsFailing tells whether any of the parents is suspect or down
+	parentsFailing(parents []string) bool
+	// children returns the hosts with host as parent which are failing
+	children(host string) []string
+	// silenced tells whether the events of host are silenced now
+	silenced(host string, tags []string) bool
+	// changed is told the state of the monitor has changed
+	changed(m *Monitor)
+}
+
+// MonitorStatus is a snapshot of the state of a Monitor
+type MonitorStatus struct {
+	Host       string
+	Down       bool
+	State      State
+	Failures   int               // consecutive failed pings
+	Successes  int               // consecutive successful pings while down
+	LastError  error             // error of the last failed ping
+	LastCheck  time.Time         // zero if never pinged
+	LastChange time.Time         // last transition or when monitoring started
+	RTT        time.Duration     // round-trip time of the last successful ping
+	Attrs      map[string]string // details of the last check
+	MTU        int               // path MTU found by the last check, 0 if unknown
+	Flapping   bool              // events are held back until it settles
+	Silenced   bool              // events are held back until the silence ends
+	Settings   Settings
+
+	// Totals since monitoring started
+	ChecksUp    uint64
+	ChecksDown  uint64
+	Transitions uint64
+}
+
 // Monitor is the main structure that represent a monitored host
-// Whenever a host goes up or down it notifies it on the corresponding channel
+// Whenever a host goes up or down it notifies it on the corresponding channel.
+// Its checks are run by the pool scheduler.
 type Monitor struct {
-	running   *sync.Mutex // monitor must run only once
-	lock      *sync.Mutex // protects internal values
-	ping      PingFunc
-	host      string
-	down      bool
-	failures  int
-	failLimit int
-	interval  time.Duration
-	stop      bool
-	notifyCh  chan<- HostStatus
+	lock         *sync.Mutex // protects internal values
+	checker      Checker
+	host         string
+	state        State
+	failures     int
+	successes    int
+	settings     Settings
+	own          Settings // as given, without the pool defaults
+	lastErr      error
+	lastCheck    time.Time
+	lastChange   time.Time
+	rtt          time.Duration
+	attrs        map[string]string
+	notifyCh     chan<- HostStatus
+	deps         dependencies  // nil if the host can't have parents
+	tracer       Tracer        // nil if DOWN events are not traced
+	traceTimeout time.Duration // of the traceroute delaying the DOWN events
+	held         bool          // down but never notified as its parents were failing
+	last         State         // status on the last transition
+	history      *history
+
+	checksUp    uint64
+	checksDown  uint64
+	transitions uint64
+
+	degraded bool // over the thresholds while up
+	slow     int  // checks in a row on the other side of the thresholds
+
+	mtu     int // path MTU found by the last check
+	toldMTU int // path MTU on the last event
+
+	changes   []time.Time // status changes within the flap window
+	flapping  bool
+	flapStart time.Time
+	flapDown  bool // status notified before flapping
+
+	silenced     bool
+	silenceStart time.Time
+	silenceDown  bool // status before the silence
 }
 
 // NewMonitor takes a host, an initial state, and the notification channels and returns a monitorable host structure
-func NewMonitor(status HostStatus, ping PingFunc, notifyCh chan<- HostStatus) *Monitor {
+func NewMonitor(status HostStatus, checker Checker, notifyCh chan<- HostStatus) *Monitor {
 	h := Monitor{
-		ping:     ping,
-		host:     status.Host,
-		down:     status.Down,
-		notifyCh: notifyCh,
-		running:  &sync.Mutex{},
-		lock:     &sync.Mutex{},
+		checker:    checker,
+		host:       status.Host,
+		state:      initialState(status.Down),
+		last:       initialState(status.Down),
+		history:    newHistory(DefaultHistorySize, status.Down),
+		lastChange: time.Now(),
+		notifyCh:   notifyCh,
+		lock:       &sync.Mutex{},
 	}
 
 	return &h
 }
 
-// Start begins the periodic pinging of the host
-func (m *Monitor) Start(interval time.Duration, failLimit int) {
-	m.running.Lock()
-	defer m.running.Unlock()
+// Status returns a snapshot of the monitor state
+func (m *Monitor) Status() MonitorStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return MonitorStatus{
+		Host:       m.host,
+		Down:       m.state.Down(),
+		State:      m.current(),
+		Failures:   m.failures,
+		Successes:  m.successes,
+		LastError:  m.lastErr,
+		LastCheck:  m.lastCheck,
+		LastChange: m.lastChange,
+		RTT:        m.rtt,
+		Attrs:      m.attrs,
+		MTU:        m.mtu,
+		Flapping:   m.flapping,
+		Silenced:   m.silenced,
+		Settings:   m.settings,
+
+		ChecksUp:    m.checksUp,
+		ChecksDown:  m.checksDown,
+		Transitions: m.transitions,
+	}
+}
+
+// History returns the recent checks and transitions of the monitor
+func (m *Monitor) History() History {
+	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	return m.history.snapshot()
+}
+
+// reset sets the monitor back to the given initial state
+func (m *Monitor) reset(status HostStatus) {
 	m.lock.Lock()
-	m.interval = interval
-	m.failLimit = failLimit
-	m.stop = false
+	defer m.lock.Unlock()
+
+	m.state = initialState(status.Down)
+	m.last = m.state
+	m.degraded = false
+	m.slow = 0
+	m.mtu, m.toldMTU = 0, 0
+	m.failures = 0
+	m.successes = 0
+	m.lastErr = nil
+	m.lastChange = time.Now()
+	m.held = false
+	m.changes = nil
+	m.flapping = false
+	m.silenced = false
+}
+
+// configure sets the settings and the checker of the host,
+// the settings must have all values set.
+func (m *Monitor) configure(s Settings, checker Checker) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.settings = s
+	m.checker = checker
+}
+
+// check checks the host once and updates its status, unless ctx
+// is cancelled, which means the monitor has been stopped.
+func (m *Monitor) check(ctx context.Context) {
+	m.lock.Lock()
+	checker, settings := m.checker, m.settings
+	timeout, parents, tags := settings.Timeout, settings.Parents, settings.Tags
+	was := m.current()
 	m.lock.Unlock()
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	checkCtx := withSettings(ctx, settings)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		checkCtx, cancel = context.WithTimeout(checkCtx, timeout)
+		defer cancel()
+	}
 
-	for _ = range ticker.C {
-		//		log.Println("tick")
-		m.lock.Lock()
-		if m.stop {
-			return
-		}
-		m.lock.Unlock()
-
-		if up, err := m.ping(m.host); up {
-			//			log.Println(m.host.Host + " pong")
-			m.markUp()
-		} else {
-			//			log.Println(m.host.Host + " failed")
-			m.markDown(err)
+	r := checker.Check(checkCtx, m.host)
+	if ctx.Err() != nil {
+		return
+	}
+	if !r.Up && checkCtx.Err() == context.DeadlineExceeded {
+		r.Err = ErrTimeout
+	}
+
+	var event HostStatus
+	var changed bool
+
+	if r.Up {
+		//			log.Println(m.host.Host + " pong")
+		event, changed = m.markUp(r)
+	} else {
+		//			log.Println(m.host.Host + " failed")
+		unreachable := m.deps != nil && len(parents) > 0 && m.deps.parentsFailing(parents)
+		event, changed = m.markDown(r, unreachable)
+	}
+	if m.deps != nil && m.Status().State != was {
+		m.deps.changed(m)
+	}
+	silenced := m.deps != nil && m.deps.silenced(m.host, tags)
+	event, changed = m.flap(event, changed)
+	event, changed = m.silence(event, changed, silenced)
+	if !changed && !silenced {
+		event, changed = m.pathMTU()
+	}
+
+	if changed && event.Down && m.deps != nil {
+		event.Children = m.deps.children(m.host)
+	}
+	if changed && event.Down && m.tracer != nil {
+		event.Route = m.trace(withSettings(ctx, settings))
+	}
+
+	// sent without holding the lock so a busy
+	// notification channel doesn't block Status
+	if changed {
+		m.notifyCh <- event
+	}
+}
+
+// trace returns the route to the host, nil if it can't be traced. It
+// runs on the worker before the DOWN event is sent, so it's given up
+// to traceTimeout, the hops past a break never answer.
+func (m *Monitor) trace(ctx context.Context) *ping.Route {
+	ctx, cancel := context.WithTimeout(ctx, m.traceTimeout)
+	defer cancel()
+
+	r, err := m.tracer.Trace(ctx, m.host)
+	if err != nil {
+		log.Println("ERROR tracing " + m.host + ": " + err.Error())
+		return nil
+	}
+
+	return &r
+}
+
+// markUp resets the failure count, if the host is down and has
+// answered RecoverLimit times in a row, it changes the status to up
+// and then returns the event telling that the host is up.
+func (m *Monitor) markUp(r Result) (HostStatus, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	defer m.record(r)
+	m.lastCheck = time.Now()
+	m.lastErr = nil
+	m.rtt = r.RTT
+	m.attrs = r.Attrs
+	m.failures = 0
+	if r.MTU > 0 {
+		m.mtu = r.MTU
+	}
+
+	switch m.state {
+	case StateSuspect:
+		m.state = StateUp
+	case StateDown, StateRecovering, StateUnreachable:
+		m.successes++
+		m.state = StateRecovering
+		if m.successes >= m.settings.RecoverLimit {
+			m.state = StateUp
+			m.successes = 0
+			if m.held {
+				// nobody was told it was down
+				m.held = false
+				return HostStatus{}, false
+			}
+			return m.transition(nil), true
 		}
+		return HostStatus{}, false
+	}
+
+	return m.degrade(r)
+}
+
+// degrade compares the result of an up host with the thresholds and
+// returns the event telling it's degraded after DegradeLimit checks
+// in a row over them, or up again after as many under them.
+// Must be called holding m.lock.
+func (m *Monitor) degrade(r Result) (HostStatus, bool) {
+	var reason error
+	if max := m.settings.MaxRTT; max > 0 && r.RTT > max {
+		reason = fmt.Errorf("rtt %s over %s", r.RTT, max)
+	} else if max := m.settings.MaxLoss; max > 0 && r.Loss > max {
+		reason = fmt.Errorf("loss %g%% over %g%%", r.Loss, max)
+	} else if min := m.settings.MinMTU; min > 0 && r.MTU > 0 && r.MTU < min {
+		reason = fmt.Errorf("path mtu %d under %d", r.MTU, min)
 	}
+
+	if (reason != nil) == m.degraded {
+		m.slow = 0
+		return HostStatus{}, false
+	}
+
+	m.slow++
+	if m.slow < m.settings.DegradeLimit {
+		return HostStatus{}, false
+	}
+
+	m.slow = 0
+	m.degraded = reason != nil
+	return m.transition(reason), true
 }
 
-// Stop stops pinging the host
-func (m *Monitor) Stop() {
+// pathMTU returns the event telling the path MTU of an up host changed
+// since the last event, unless it's flapping. The first MTU found is
+// not notified.
+func (m *Monitor) pathMTU() (HostStatus, bool) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	m.stop = true
+	if m.mtu == m.toldMTU || m.state != StateUp || m.flapping {
+		return HostStatus{}, false
+	}
+	was := m.toldMTU
+	m.toldMTU = m.mtu
+	if was == 0 {
+		return HostStatus{}, false
+	}
+
+	state := m.current()
+	return HostStatus{
+		Host:        m.host,
+		Degraded:    state == StateDegraded,
+		Reason:      fmt.Errorf("path mtu %d, was %d", m.mtu, was),
+		WasDegraded: state == StateDegraded,
+		Time:        m.lastCheck,
+		RTT:         m.rtt,
+		Attrs:       m.attrs,
+		MTU:         m.mtu,
+		WasMTU:      was,
+	}, true
 }
 
-// markUp resets the failure count and the host status, then sends a channel notification that the host is up.
-func (m *Monitor) markUp() {
+// markDown resets the success count, if the host is up and has
+// failed FailLimit times in a row, it changes the status to down
+// and then returns the event telling that the host is down. While
+// unreachable the event is held back until the parents are up again.
+func (m *Monitor) markDown(r Result, unreachable bool) (HostStatus, bool) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	if !m.down {
-		m.failures = 0
-		return
+	defer m.record(r)
+	m.lastCheck = time.Now()
+	m.lastErr = r.Err
+	m.attrs = r.Attrs
+	m.successes = 0
+
+	switch m.state {
+	case StateDown, StateRecovering, StateUnreachable:
+		m.state = StateDown
+		m.failures = m.settings.FailLimit
+		if m.held {
+			return m.release(r.Err, unreachable)
+		}
+	case StateUp, StateSuspect:
+		m.failures++
+		m.state = StateSuspect
+		if m.failures >= m.settings.FailLimit {
+			m.state = StateDown
+			m.degraded = false
+			m.slow = 0
+			m.held = true
+			return m.release(r.Err, unreachable)
+		}
 	}
 
-	m.failures--
-	if m.failures > 0 {
-		return
+	return HostStatus{}, false
+}
+
+// record adds the check result to the history once the
+// status has been updated, must be called holding m.lock.
+func (m *Monitor) record(r Result) {
+	if r.Up {
+		m.checksUp++
+	} else {
+		m.checksDown++
+	}
+	m.history.add(CheckRecord{Time: m.lastCheck, Up: r.Up, RTT: r.RTT, Err: r.Err}, m.state.Down())
+}
+
+// release sends the held DOWN event if the host is reachable,
+// otherwise it keeps it unreachable. Must be called holding m.lock.
+func (m *Monitor) release(reason error, unreachable bool) (HostStatus, bool) {
+	if unreachable {
+		m.state = StateUnreachable
+		return HostStatus{}, false
 	}
 
-	m.down = false
-	m.notifyCh <- HostStatus{Host: m.host, Down: m.down}
+	m.held = false
+	return m.transition(reason), true
 }
 
-// markDown does nothing if the host is already down. If it's up, in increases the failure count
-// changes the status to down and then sends a channel notification that the host is down.
-func (m *Monitor) markDown(err error) {
+// flap records the status changes and holds back their events while the
+// host is flapping. It returns the FLAPPING event when the host changes
+// status FlapLimit times within FlapWindow, and an event with the final
+// status once it has not changed for a whole FlapWindow.
+func (m *Monitor) flap(event HostStatus, changed bool) (HostStatus, bool) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	if m.down {
-		m.failures = m.failLimit
-		return
+
+	window, limit := m.settings.FlapWindow, m.settings.FlapLimit
+	if window <= 0 || limit <= 0 {
+		return event, changed
 	}
 
-	m.failures++
-	if m.failures < m.failLimit {
-		return
+	now := m.lastCheck
+	if changed {
+		m.changes = append(m.changes, now)
+	}
+	old := 0
+	for old < len(m.changes) && now.Sub(m.changes[old]) >= window {
+		old++
+	}
+	m.changes = m.changes[old:]
+
+	if !m.flapping {
+		if !changed || len(m.changes) < limit {
+			return event, changed
+		}
+
+		m.flapping = true
+		m.flapStart = now
+		m.flapDown = event.WasDown
+		event.Flapping = true
+		return event, true
+	}
+
+	if len(m.changes) > 0 {
+		return HostStatus{}, false
+	}
+
+	m.flapping = false
+	h := HostStatus{
+		Host:     m.host,
+		Down:     m.state.Down(),
+		Degraded: m.current() == StateDegraded,
+		Time:     now,
+		WasDown:  m.flapDown,
+		Since:    now.Sub(m.flapStart),
+		Failures: m.failures,
+		RTT:      m.rtt,
+		Attrs:    m.attrs,
+	}
+	if h.Down {
+		h.Reason = m.lastErr
+	}
+
+	return h, true
+}
+
+// silence holds back the events while the host is silenced, once
+// the silence is over it returns an event with the current status
+// if it's not the same as before the silence.
+func (m *Monitor) silence(event HostStatus, changed, silenced bool) (HostStatus, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if silenced {
+		if !m.silenced {
+			m.silenced = true
+			m.silenceStart = m.lastCheck
+			m.silenceDown = m.state.Down()
+			if changed {
+				m.silenceDown = event.WasDown
+			}
+		}
+		return HostStatus{}, false
+	}
+
+	if !m.silenced {
+		return event, changed
+	}
+
+	m.silenced = false
+	if m.state.Down() == m.silenceDown {
+		return HostStatus{}, false
+	}
+
+	h := HostStatus{
+		Host:     m.host,
+		Down:     m.state.Down(),
+		Degraded: m.current() == StateDegraded,
+		Time:     m.lastCheck,
+		WasDown:  m.silenceDown,
+		Since:    m.lastCheck.Sub(m.silenceStart),
+		Failures: m.failures,
+		RTT:      m.rtt,
+		Attrs:    m.attrs,
+		Silenced: true,
+	}
+	if h.Down {
+		h.Reason = m.lastErr
+	}
+
+	return h, true
+}
+
+// transition records a status change that just happened and returns
+// the event describing it, must be called holding m.lock.
+func (m *Monitor) transition(reason error) HostStatus {
+	now, was := m.lastCheck, m.last
+	m.last = m.current()
+	m.transitions++
+	h := HostStatus{
+		Host:        m.host,
+		Down:        m.last.Down(),
+		Degraded:    m.last == StateDegraded,
+		Reason:      reason,
+		Time:        now,
+		WasDown:     was.Down(),
+		WasDegraded: was == StateDegraded,
+		Since:       now.Sub(m.lastChange),
+		Failures:    m.failures,
+		RTT:         m.rtt,
+		Attrs:       m.attrs,
+		MTU:         m.mtu,
+	}
+	m.lastChange = now
+	m.toldMTU = m.mtu
+
+	return h
+}
+
+// current returns the state telling apart
+// degraded hosts, must be called holding m.lock.
+func (m *Monitor) current() State {
+	if m.state == StateUp && m.degraded {
+		return StateDegraded
 	}
+	return m.state
+}
 
-	m.down = true
-	m.notifyCh <- HostStatus{Host: m.host, Down: m.down, Reason: err}
+// initialState is the state of a monitor starting up or down
+func initialState(down bool) State {
+	if down {
+		return StateDown
+	}
+	return StateUp
 }
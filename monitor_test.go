@@ -0,0 +1,204 @@
+package pingd
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMonitorStates tests the transitions between states, a single
+// reply during an outage must not bring the host back up
+func TestMonitorStates(t *testing.T) {
+	m := NewMonitor(HostStatus{Host: "h1"}, nil, nil)
+	m.configure(Settings{FailLimit: 3, RecoverLimit: 2}, nil)
+
+	steps := []struct {
+		up      bool
+		state   State
+		changed bool
+	}{
+		{false, StateSuspect, false},
+		{true, StateUp, false},
+		{false, StateSuspect, false},
+		{false, StateSuspect, false},
+		{false, StateDown, true},
+		{true, StateRecovering, false},
+		{false, StateDown, false},
+		{true, StateRecovering, false},
+		{false, StateDown, false},
+		{true, StateRecovering, false},
+		{true, StateUp, true},
+		{true, StateUp, false},
+	}
+
+	for i, step := range steps {
+		var event HostStatus
+		var changed bool
+		if step.up {
+			event, changed = m.markUp(Result{Up: true})
+		} else {
+			event, changed = m.markDown(Result{Err: errTestPing}, false)
+		}
+
+		status := m.Status()
+		if status.State != step.state || status.Down != step.state.Down() || changed != step.changed {
+			t.Fatalf("Got state: %s changed: %t at step %d, expected: %s %t", status.State, changed, i, step.state, step.changed)
+		}
+		if changed && event.Down != step.state.Down() {
+			t.Errorf("Got event: %s at step %d, expected state: %s", event, i, step.state)
+		}
+	}
+}
+
+func TestStateString(t *testing.T) {
+	for state, name := range map[State]string{StateUp: "up", StateSuspect: "suspect", StateDown: "down", StateRecovering: "recovering", State(9): "unknown"} {
+		if state.String() != name {
+			t.Errorf("Got state name: %s, expected: %s", state, name)
+		}
+	}
+}
+
+// TestMonitorFlapping tests that a flapping host sends one FLAPPING
+// event and then its final status once it settles
+func TestMonitorFlapping(t *testing.T) {
+	window := 50 * time.Millisecond
+	m := NewMonitor(HostStatus{Host: "h1"}, nil, nil)
+	m.configure(Settings{FailLimit: 1, RecoverLimit: 1, FlapWindow: window, FlapLimit: 3}, nil)
+
+	check := func(up bool) (HostStatus, bool) {
+		if up {
+			return m.flap(m.markUp(Result{Up: true}))
+		}
+		return m.flap(m.markDown(Result{Err: errTestPing}, false))
+	}
+
+	if event, changed := check(false); !changed || !event.Down || event.Flapping {
+		t.Errorf("Got event: %s, expected DOWN", event)
+	}
+	if event, changed := check(true); !changed || event.Down || event.Flapping {
+		t.Errorf("Got event: %s, expected UP", event)
+	}
+	if event, changed := check(false); !changed || !event.Down || !event.Flapping {
+		t.Errorf("Got event: %s, expected FLAPPING", event)
+	}
+	for _, up := range []bool{true, false, true, false} {
+		if event, changed := check(up); changed {
+			t.Errorf("Got event: %s, expected none while flapping", event)
+		}
+	}
+	if !m.Status().Flapping {
+		t.Error("Got status not flapping, expected flapping")
+	}
+
+	time.Sleep(window)
+	event, changed := check(false)
+	if !changed || !event.Down || event.WasDown || event.Flapping || event.Reason != errTestPing {
+		t.Errorf("Got event: %s, expected DOWN after flapping", event)
+	}
+	if event.Since < window {
+		t.Errorf("Got flapping for %s, expected at least %s", event.Since, window)
+	}
+	if event, changed := check(false); changed || m.Status().Flapping {
+		t.Errorf("Got event: %s, expected none once settled", event)
+	}
+}
+
+// TestMonitorUnreachable tests that the events of a host failing
+// while its parents fail are held back until they are up again
+func TestMonitorUnreachable(t *testing.T) {
+	m := NewMonitor(HostStatus{Host: "h1"}, nil, nil)
+	m.configure(Settings{FailLimit: 1, RecoverLimit: 1}, nil)
+
+	if _, changed := m.markDown(Result{Err: errTestPing}, true); changed || m.Status().State != StateUnreachable {
+		t.Errorf("Got state: %s changed: %t, expected: unreachable without event", m.Status().State, changed)
+	}
+	if _, changed := m.markUp(Result{Up: true}); changed || m.Status().State != StateUp {
+		t.Errorf("Got state: %s changed: %t, expected: up without event", m.Status().State, changed)
+	}
+
+	m.markDown(Result{Err: errTestPing}, true)
+	if event, changed := m.markDown(Result{Err: errTestPing}, false); !changed || !event.Down || m.Status().State != StateDown {
+		t.Errorf("Got state: %s event: %s, expected DOWN once the parents are up", m.Status().State, event)
+	}
+	if event, changed := m.markUp(Result{Up: true}); !changed || event.Down {
+		t.Errorf("Got event: %s, expected UP", event)
+	}
+}
+
+// TestMonitorSilence tests that no events are sent during a silence
+// and that its end tells the status if it changed
+func TestMonitorSilence(t *testing.T) {
+	m := NewMonitor(HostStatus{Host: "h1"}, nil, nil)
+	m.configure(Settings{FailLimit: 1, RecoverLimit: 1}, nil)
+
+	check := func(up, silenced bool) (HostStatus, bool) {
+		if up {
+			event, changed := m.markUp(Result{Up: true})
+			return m.silence(event, changed, silenced)
+		}
+		event, changed := m.markDown(Result{Err: errTestPing}, false)
+		return m.silence(event, changed, silenced)
+	}
+
+	// down and back up during the silence
+	for _, up := range []bool{false, true} {
+		if event, changed := check(up, true); changed {
+			t.Errorf("Got event: %s, expected none while silenced", event)
+		}
+	}
+	if event, changed := check(true, false); changed {
+		t.Errorf("Got event: %s, expected none as the status didn't change", event)
+	}
+
+	// down during the silence
+	check(false, true)
+	if !m.Status().Silenced {
+		t.Error("Got status not silenced, expected silenced")
+	}
+	event, changed := check(false, false)
+	if !changed || !event.Down || event.WasDown || !event.Silenced || event.Reason != errTestPing {
+		t.Errorf("Got event: %s, expected DOWN after the silence", event)
+	}
+}
+
+// TestMonitorDegraded tests that a host over the thresholds for
+// DegradeLimit checks in a row is degraded until it's under them as long
+func TestMonitorDegraded(t *testing.T) {
+	m := NewMonitor(HostStatus{Host: "h1"}, nil, nil)
+	m.configure(Settings{FailLimit: 2, RecoverLimit: 1, MaxRTT: 100 * time.Millisecond, MaxLoss: 20, DegradeLimit: 2}, nil)
+
+	fast := Result{Up: true, RTT: 10 * time.Millisecond}
+	slow := Result{Up: true, RTT: 800 * time.Millisecond}
+	lossy := Result{Up: true, RTT: 10 * time.Millisecond, Loss: 30}
+
+	for _, r := range []Result{fast, slow, fast, slow} {
+		if event, changed := m.markUp(r); changed {
+			t.Errorf("Got event: %s, expected none", event)
+		}
+	}
+
+	event, changed := m.markUp(slow)
+	if !changed || !event.Degraded || event.WasDegraded || event.Down || event.Reason == nil {
+		t.Errorf("Got event: %s, expected DEGRADED", event)
+	}
+	if status := m.Status(); status.State != StateDegraded || status.Down {
+		t.Errorf("Got state: %s, expected: %s", status.State, StateDegraded)
+	}
+
+	for _, r := range []Result{lossy, fast, lossy, fast} {
+		if event, changed := m.markUp(r); changed {
+			t.Errorf("Got event: %s, expected none while degraded", event)
+		}
+	}
+	event, changed = m.markUp(fast)
+	if !changed || event.Degraded || !event.WasDegraded || event.Down {
+		t.Errorf("Got event: %s, expected UP from degraded", event)
+	}
+
+	m.markUp(slow)
+	m.markUp(slow)
+	m.markDown(Result{Err: errTestPing}, false)
+	event, changed = m.markDown(Result{Err: errTestPing}, false)
+	if !changed || !event.Down || !event.WasDegraded || event.Degraded {
+		t.Errorf("Got event: %s, expected DOWN from degraded", event)
+	}
+}
@@ -0,0 +1,171 @@
+package pingd
+
+import (
+	"sync"
+)
+
+// DefaultQueueSize is the number of events a Sink
+// without QueueSize can hold before overflowing
+const DefaultQueueSize = 100
+
+// Overflow is what a Sink does with a new event when its queue is full
+type Overflow int
+
+const (
+	// Block waits until the Notifier makes room, slowing down
+	// the delivery of events to all the other sinks
+	Block Overflow = iota
+
+	// DropOldest discards the oldest event on the queue
+	DropOldest
+
+	// Coalesce replaces the queued event of the same host with
+	// the new one, if there's none it discards the oldest event
+	Coalesce
+)
+
+// Sink is a Notifier with its own queue of events, so a slow
+// Notifier doesn't hold back the others.
+type Sink struct {
+	Name      string // used on the stats
+	Notify    Notifier
+	QueueSize int // DefaultQueueSize if not set
+	Overflow  Overflow
+}
+
+// SinkStats are the delivery counters of a Sink
+type SinkStats struct {
+	Name      string
+	Queued    int    // events waiting for the Notifier
+	Delivered uint64 // events handed to the Notifier
+	Dropped   uint64 // events discarded because the queue was full
+	Coalesced uint64 // events replaced by a newer one of the same host
+}
+
+// sinkQueue is the bounded queue between the pool and a Notifier
+type sinkQueue struct {
+	sink     Sink
+	lock     sync.Mutex
+	cond     *sync.Cond // signals any change on events or closed
+	events   []HostStatus
+	closed   bool
+	counters SinkStats
+}
+
+func newSinkQueue(sink Sink) *sinkQueue {
+	if sink.QueueSize <= 0 {
+		sink.QueueSize = DefaultQueueSize
+	}
+
+	q := &sinkQueue{sink: sink}
+	q.cond = sync.NewCond(&q.lock)
+	q.counters.Name = sink.Name
+
+	return q
+}
+
+// run feeds the Notifier with the queued events until the
+// queue is closed and empty, then returns once the Notifier does.
+func (q *sinkQueue) run() {
+	ch := make(chan HostStatus)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.sink.Notify(ch)
+	}()
+
+	for {
+		h, ok := q.pop()
+		if !ok {
+			break
+		}
+		ch <- h
+	}
+
+	close(ch)
+	<-done
+}
+
+// push queues an event applying the overflow policy if it's full
+func (q *sinkQueue) push(h HostStatus) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for q.sink.Overflow == Block && len(q.events) >= q.sink.QueueSize {
+		q.cond.Wait()
+	}
+
+	if len(q.events) >= q.sink.QueueSize {
+		if q.sink.Overflow == Coalesce {
+			for i := len(q.events) - 1; i >= 0; i-- {
+				if q.events[i].Host == h.Host {
+					q.events[i] = h
+					q.counters.Coalesced++
+					return
+				}
+			}
+		}
+
+		q.events = q.events[1:]
+		q.counters.Dropped++
+	}
+
+	q.events = append(q.events, h)
+	q.cond.Broadcast()
+}
+
+// pop waits for the next event, returns false
+// once the queue is closed and empty.
+func (q *sinkQueue) pop() (HostStatus, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.events) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	if len(q.events) == 0 {
+		return HostStatus{}, false
+	}
+
+	h := q.events[0]
+	q.events = q.events[1:]
+	q.counters.Delivered++
+	q.cond.Broadcast()
+
+	return h, true
+}
+
+// close lets the queue drain and then stops it
+func (q *sinkQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// stats returns the current counters
+func (q *sinkQueue) stats() SinkStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	stats := q.counters
+	stats.Queued = len(q.events)
+
+	return stats
+}
+
+// fanOut copies every event to all the queues until events is closed,
+// then closes the queues.
+func fanOut(events <-chan HostStatus, queues []*sinkQueue) {
+	for h := range events {
+		for _, q := range queues {
+			q.push(h)
+		}
+	}
+
+	for _, q := range queues {
+		q.close()
+	}
+}
@@ -0,0 +1,140 @@
+package pingd
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+)
+
+var overflowtests = []struct {
+	overflow  Overflow
+	push      []string
+	queued    []string
+	dropped   uint64
+	coalesced uint64
+}{
+	{DropOldest, []string{"h1", "h2", "h3", "h4"}, []string{"h2", "h3", "h4"}, 1, 0},
+	{Coalesce, []string{"h1", "h2", "h3", "h2", "h4"}, []string{"h2", "h3", "h4"}, 1, 1},
+}
+
+// TestSinkOverflow tests the policies applied when a queue is full
+func TestSinkOverflow(t *testing.T) {
+	for _, tt := range overflowtests {
+		q := newSinkQueue(Sink{QueueSize: 3, Overflow: tt.overflow})
+		for i, host := range tt.push {
+			q.push(HostStatus{Host: host, Failures: i})
+		}
+		q.close()
+
+		var queued []string
+		for h, ok := q.pop(); ok; h, ok = q.pop() {
+			queued = append(queued, h.Host)
+		}
+
+		if len(queued) != len(tt.queued) {
+			t.Errorf("Got queued: %v, expected: %v", queued, tt.queued)
+			continue
+		}
+		for i := range queued {
+			if queued[i] != tt.queued[i] {
+				t.Errorf("Got queued: %v, expected: %v", queued, tt.queued)
+				break
+			}
+		}
+
+		stats := q.stats()
+		if stats.Dropped != tt.dropped || stats.Coalesced != tt.coalesced || stats.Delivered != uint64(len(tt.queued)) {
+			t.Errorf("Got stats: %+v, expected: %d dropped, %d coalesced", stats, tt.dropped, tt.coalesced)
+		}
+	}
+}
+
+// TestSinkBlock tests that a blocking queue waits for room
+func TestSinkBlock(t *testing.T) {
+	q := newSinkQueue(Sink{QueueSize: 1, Overflow: Block})
+	q.push(HostStatus{Host: "h1"})
+
+	pushed := make(chan struct{})
+	go func() {
+		q.push(HostStatus{Host: "h2"})
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("Push on a full blocking queue didn't block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if h, _ := q.pop(); h.Host != "h1" {
+		t.Errorf("Got event: %s, expected: h1", h.Host)
+	}
+	<-pushed
+	if h, _ := q.pop(); h.Host != "h2" {
+		t.Errorf("Got event: %s, expected: h2", h.Host)
+	}
+}
+
+// TestSinks tests that a stuck Notifier doesn't hold back the others
+// and still gets its queued events on shutdown
+func TestSinks(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	seq := make(map[string][]bool)
+	for _, host := range []string{"h1", "h2", "h3", "h4"} {
+		seq[host] = []bool{false}
+	}
+
+	fast := make(chan HostStatus)
+	release := make(chan struct{})
+	var slow []HostStatus
+
+	var pool = &Pool{
+		Interval:  time.Millisecond,
+		FailLimit: 1,
+		Load:      NewLoaderFunc([]string{"h1", "h2", "h3", "h4"}),
+		Ping:      NewTestPingFunc(seq),
+		Sinks: []Sink{
+			{Name: "fast", Notify: NewTestNotifyFunc(fast)},
+			{Name: "slow", QueueSize: 2, Overflow: DropOldest, Notify: func(notify <-chan HostStatus) {
+				<-release
+				for h := range notify {
+					slow = append(slow, h)
+				}
+			}},
+		},
+	}
+	pool.Start()
+
+	for i := 0; i < 4; i++ {
+		<-fast
+	}
+
+	// wait for the stuck Notifier to have taken one event
+	// and the rest to be either queued or dropped
+	var stats []SinkStats
+	for {
+		stats = pool.SinkStats()
+		if s := stats[1]; s.Delivered == 1 && s.Delivered+s.Dropped+uint64(s.Queued) == 4 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if stats[0].Name != "fast" || stats[0].Delivered != 4 || stats[0].Dropped != 0 {
+		t.Errorf("Got stats: %+v, expected 4 delivered", stats[0])
+	}
+	if stats[1].Name != "slow" || stats[1].Queued > 2 || stats[1].Dropped == 0 {
+		t.Errorf("Got stats: %+v, expected at most 2 queued and some dropped", stats[1])
+	}
+
+	close(release)
+	pool.Shutdown(context.Background())
+	close(fast)
+
+	if len(slow) != 1+stats[1].Queued {
+		t.Errorf("Got %d events on the slow Notifier, expected: %d", len(slow), 1+stats[1].Queued)
+	}
+}
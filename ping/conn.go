@@ -0,0 +1,241 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// Family chooses the address pinged of hosts resolving to several
+type Family int
+
+// Address families
+const (
+	AnyFamily  Family = iota // the first address resolved
+	PreferIPv4               // the first IPv4 address, or the first one if none
+	PreferIPv6               // the first IPv6 address, or the first one if none
+	OnlyIPv4                 // the first IPv4 address, an error if none
+	OnlyIPv6                 // the first IPv6 address, an error if none
+)
+
+// resolve returns the address of host to ping
+func resolve(ctx context.Context, host string, family Family) (net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := pick(addrs, family)
+	if ip == nil {
+		switch family {
+		case OnlyIPv4:
+			return nil, fmt.Errorf("no IPv4 address for %s", host)
+		case OnlyIPv6:
+			return nil, fmt.Errorf("no IPv6 address for %s", host)
+		}
+		return nil, fmt.Errorf("no address for %s", host)
+	}
+
+	return ip, nil
+}
+
+// pick returns the address of the family among addrs,
+// IPv4 ones in their 4 bytes form, or nil if none
+func pick(addrs []net.IPAddr, family Family) net.IP {
+	var first, v4, v6 net.IP
+	for _, addr := range addrs {
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			if v4 == nil {
+				v4 = ip4
+			}
+			if first == nil {
+				first = ip4
+			}
+		} else if addr.IP.To16() != nil {
+			if v6 == nil {
+				v6 = addr.IP
+			}
+			if first == nil {
+				first = addr.IP
+			}
+		}
+	}
+
+	switch family {
+	case PreferIPv4:
+		if v4 != nil {
+			return v4
+		}
+	case PreferIPv6:
+		if v6 != nil {
+			return v6
+		}
+	case OnlyIPv4:
+		return v4
+	case OnlyIPv6:
+		return v6
+	}
+	return first
+}
+
+// ErrPermission is returned when neither raw nor datagram ICMP sockets can
+// be opened, datagram ones are allowed to the groups on the sysctl
+// net.ipv4.ping_group_range, eg: sysctl net.ipv4.ping_group_range="0 2147483647"
+var ErrPermission = errors.New("no permission to open ICMP sockets, run as root or allow the group in net.ipv4.ping_group_range")
+
+// listen opens the socket of the family sending from source, an address or
+// an interface name, a raw one when privileged and otherwise a datagram one
+// if allowed
+func listen(v6 bool, source string) (PacketConn, error) {
+	conn, err := listenRaw(v6, source)
+	if err == nil || !errors.Is(err, os.ErrPermission) {
+		return conn, err
+	}
+
+	conn, derr := listenDatagram(v6, source)
+	if derr != nil {
+		return nil, fmt.Errorf("%w: %v, %v", ErrPermission, err, derr)
+	}
+	return conn, nil
+}
+
+// sourceAddr returns the address to bind to send from source, and the
+// interface name if source is not an address
+func sourceAddr(v6 bool, source string) (net.IP, string) {
+	if ip := net.ParseIP(source); ip != nil {
+		return ip, ""
+	}
+	if v6 {
+		return net.IPv6unspecified, source
+	}
+	return net.IPv4zero, source
+}
+
+// ipConn reads and writes ICMP messages on a raw socket
+type ipConn struct {
+	conn *net.IPConn
+	v6   bool
+	opts *headerOpts
+}
+
+// listenRaw opens the raw socket of the family, which requires root permissions
+func listenRaw(v6 bool, source string) (PacketConn, error) {
+	network := "ip4:icmp"
+	if v6 {
+		network = "ip6:ipv6-icmp"
+	}
+	ip, device := sourceAddr(v6, source)
+
+	c, err := net.ListenIP(network, &net.IPAddr{IP: ip})
+	if err != nil {
+		return nil, err
+	}
+	if device != "" {
+		if err := bindToDevice(c, device); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if v6 {
+		if err := recvHopLimit(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return ipConn{conn: c, v6: v6, opts: &headerOpts{v6: v6}}, nil
+}
+
+func (c ipConn) ReadFrom(b []byte) (int, int, net.IP, error) {
+	if c.v6 {
+		// IPv6 raw sockets never get the header,
+		// the hop limit comes as a control message
+		oob := make([]byte, 64)
+		n, oobn, _, addr, err := c.conn.ReadMsgIP(b, oob)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		return n, parseTTL(oob[:oobn]), addr.IP, nil
+	}
+
+	// unlike ReadFrom, ReadMsgIP keeps the IPv4 header
+	n, _, _, addr, err := c.conn.ReadMsgIP(b, nil)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	ttl := 0
+	if n >= 20 {
+		ttl = int(b[8])
+	}
+	payload := ipv4Payload(b[:n])
+	return copy(b, payload), ttl, addr.IP, nil
+}
+
+func (c ipConn) WriteTo(b []byte, dst net.IP, h Header) error {
+	return c.opts.write(c.conn, h, func() error {
+		_, err := c.conn.WriteToIP(b, &net.IPAddr{IP: dst})
+		return err
+	})
+}
+
+func (c ipConn) Close() error {
+	return c.conn.Close()
+}
+
+// engineKey tells apart the engines shared by the package functions
+type engineKey struct {
+	v6     bool
+	source string
+}
+
+// engines are the engines shared by the package functions, one
+// per address family and source, opened on first use
+var engines struct {
+	sync.Mutex
+	list map[engineKey]*Engine
+}
+
+// defaultEngine returns the shared engine of the family of
+// dst sending from source, opening its socket if needed
+func defaultEngine(dst net.IP, source string) (*Engine, error) {
+	engines.Lock()
+	defer engines.Unlock()
+
+	key := engineKey{v6: dst.To4() == nil, source: source}
+	e := engines.list[key]
+	if e == nil || e.stopped() {
+		conn, err := listen(key.v6, source)
+		if err != nil {
+			return nil, err
+		}
+		e = NewEngine(conn)
+		if engines.list == nil {
+			engines.list = make(map[engineKey]*Engine)
+		}
+		engines.list[key] = e
+	}
+
+	return e, nil
+}
+
+// EngineCounters returns the counters of the engines shared by the
+// package functions, summed over the address families and sources
+func EngineCounters() Counters {
+	engines.Lock()
+	defer engines.Unlock()
+
+	var sum Counters
+	for _, e := range engines.list {
+		c := e.Counters()
+		sum.Replies += c.Replies
+		sum.Errors += c.Errors
+		sum.Corrupted += c.Corrupted
+		sum.Foreign += c.Foreign
+	}
+
+	return sum
+}
@@ -0,0 +1,34 @@
+package ping
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPick(t *testing.T) {
+	both := []net.IPAddr{{IP: net.ParseIP("2001:db8::1")}, {IP: net.ParseIP("192.0.2.1")}}
+	v4 := []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}}
+
+	picktests := []struct {
+		addrs    []net.IPAddr
+		family   Family
+		expected string
+	}{
+		{both, AnyFamily, "2001:db8::1"},
+		{both, PreferIPv4, "192.0.2.1"},
+		{both, PreferIPv6, "2001:db8::1"},
+		{both, OnlyIPv4, "192.0.2.1"},
+		{v4, PreferIPv6, "192.0.2.1"},
+		{v4, OnlyIPv6, "<nil>"},
+	}
+
+	for _, tt := range picktests {
+		if ip := pick(tt.addrs, tt.family); ip.String() != tt.expected {
+			t.Errorf("Got address: %s for family %d, expected: %s", ip, tt.family, tt.expected)
+		}
+	}
+
+	if ip := pick(v4, AnyFamily); len(ip) != net.IPv4len {
+		t.Errorf("Got address: %v, expected in its 4 bytes form", []byte(ip))
+	}
+}
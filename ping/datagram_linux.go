@@ -0,0 +1,89 @@
+package ping
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// udpConn reads and writes ICMP messages on a datagram socket, which
+// doesn't need root permissions but the kernel sets the identifier
+// of the echoes and only delivers their replies.
+type udpConn struct {
+	conn *net.UDPConn
+	v6   bool
+	opts *headerOpts
+}
+
+// listenDatagram opens the datagram socket of the family, allowed
+// to the groups on the sysctl net.ipv4.ping_group_range
+func listenDatagram(v6 bool, source string) (PacketConn, error) {
+	ip, device := sourceAddr(v6, source)
+	family, proto, level, opt := syscall.AF_INET, syscall.IPPROTO_ICMP, syscall.IPPROTO_IP, syscall.IP_RECVTTL
+	var sa syscall.Sockaddr
+	if v6 {
+		family, proto, level, opt = syscall.AF_INET6, syscall.IPPROTO_ICMPV6, syscall.IPPROTO_IPV6, syscall.IPV6_RECVHOPLIMIT
+		sa6 := &syscall.SockaddrInet6{}
+		copy(sa6.Addr[:], ip.To16())
+		sa = sa6
+	} else {
+		sa4 := &syscall.SockaddrInet4{}
+		if ip4 := ip.To4(); ip4 != nil {
+			copy(sa4.Addr[:], ip4)
+		} else {
+			return nil, fmt.Errorf("source %s is not an IPv4 address", ip)
+		}
+		sa = sa4
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+
+	if device != "" {
+		if err := syscall.BindToDevice(fd, device); err != nil {
+			return nil, os.NewSyscallError("bindtodevice", err)
+		}
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := syscall.SetsockoptInt(fd, level, opt, 1); err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+
+	// the socket is taken as UDP as its address is an IP and port
+	c, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	return udpConn{conn: c.(*net.UDPConn), v6: v6, opts: &headerOpts{v6: v6}}, nil
+}
+
+func (c udpConn) ReadFrom(b []byte) (int, int, net.IP, error) {
+	oob := make([]byte, 64)
+	n, oobn, _, addr, err := c.conn.ReadMsgUDP(b, oob)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	return n, parseTTL(oob[:oobn]), addr.IP, nil
+}
+
+func (c udpConn) WriteTo(b []byte, dst net.IP, h Header) error {
+	return c.opts.write(c.conn, h, func() error {
+		_, err := c.conn.WriteToUDP(b, &net.UDPAddr{IP: dst})
+		return err
+	})
+}
+
+func (c udpConn) Close() error {
+	return c.conn.Close()
+}
+
+// datagram tells the engine the kernel sets the echo identifiers
+func (c udpConn) datagram() {}
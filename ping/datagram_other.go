@@ -0,0 +1,12 @@
+//go:build !linux
+
+package ping
+
+import (
+	"errors"
+)
+
+// listenDatagram is only supported on Linux
+func listenDatagram(v6 bool, source string) (PacketConn, error) {
+	return nil, errors.New("datagram ICMP sockets not supported")
+}
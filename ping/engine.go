@@ -0,0 +1,426 @@
+package ping
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoReply is returned when an echo gets no reply before its timeout
+	ErrNoReply = errors.New("no echo reply")
+
+	// ErrClosed is returned by the echoes of a closed Engine
+	ErrClosed = errors.New("engine closed")
+
+	// ErrBusy is returned when every identifier and
+	// sequence pair is taken by a pending echo
+	ErrBusy = errors.New("too many echoes pending")
+)
+
+// PacketConn is the socket an Engine sends echoes and reads replies, and
+// the ICMP errors caused by the echoes, on. It's replaced by a fake
+// network on tests.
+type PacketConn interface {
+	// ReadFrom reads an ICMP message into b, without the IP header,
+	// and returns its length, the TTL it arrived with and its source
+	ReadFrom(b []byte) (n, ttl int, src net.IP, err error)
+	// WriteTo sends the ICMP message b to dst with the IP header fields of h
+	WriteTo(b []byte, dst net.IP, h Header) error
+	// Close makes ReadFrom return an error
+	Close() error
+}
+
+// Header sets the IP header fields of an echo,
+// zero values leave the defaults of the socket
+type Header struct {
+	TTL          int  // IP TTL, or hop limit on IPv6
+	TOS          int  // IP type of service, or traffic class on IPv6, DSCP << 2, eg: 184 for EF
+	DontFragment bool // fail instead of fragmenting packets over the path MTU
+}
+
+// datagramConn is implemented by the sockets where the kernel sets the
+// identifier of the echoes, their replies are matched by sequence only
+type datagramConn interface {
+	datagram()
+}
+
+// Engine sends echoes over a single socket shared by all the pings
+// of an address family, ICMPv6 echoes to IPv6 addresses. Each echo
+// gets its own identifier and sequence pair and replies are matched
+// to them by that pair and their source, echoes without reply expire
+// together on a timer.
+type Engine struct {
+	conn     PacketConn
+	wake     chan struct{} // the first deadline may have changed
+	datagram bool          // replies are matched by sequence only
+
+	lock     sync.Mutex // protects all fields below
+	next     uint32     // counter giving out identifier and sequence pairs
+	base     int        // first identifier
+	pending  map[echoKey]*echo
+	timeouts echoHeap
+	err      error // why the engine stopped
+	counters Counters
+}
+
+// Counters counts the messages read by an Engine
+type Counters struct {
+	Replies   uint64 // echo replies matched to an echo
+	Errors    uint64 // ICMP errors matched to an echo
+	Corrupted uint64 // with a wrong checksum, malformed or not echoing the payload
+	Foreign   uint64 // echo replies and errors of no pending echo, or from another host
+}
+
+type echoKey struct {
+	id, seq int
+}
+
+// echo is a request waiting for its reply
+type echo struct {
+	key      echoKey
+	dst      net.IP
+	sent     time.Time
+	deadline time.Time
+	data     []byte // payload the reply must echo
+	index    int    // on the timeouts heap
+	redirect net.IP // gateway told by a redirect
+	done     chan echoResult
+}
+
+type echoResult struct {
+	reply Reply
+	err   error
+}
+
+// NewEngine starts reading replies from conn until Close is called
+func NewEngine(conn PacketConn) *Engine {
+	e := &Engine{
+		conn:    conn,
+		wake:    make(chan struct{}, 1),
+		base:    os.Getpid() & 0xffff,
+		pending: make(map[echoKey]*echo),
+	}
+	_, e.datagram = conn.(datagramConn)
+	go e.read()
+	go e.expire()
+
+	return e
+}
+
+// Close closes the socket, waiting echoes return ErrClosed
+func (e *Engine) Close() error {
+	e.stop(ErrClosed)
+	return e.conn.Close()
+}
+
+// Echo sends an echo request to dst with the payload size and IP header
+// of opts and returns its reply, it gives up after opts.Timeout or when
+// ctx is done, whatever happens first. On an *ICMPError the reply has
+// the router which sent it and its round-trip time.
+func (e *Engine) Echo(ctx context.Context, dst net.IP, opts Options) (Reply, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = TimeOut
+	}
+
+	request := icmpv4EchoRequest
+	if dst.To4() == nil {
+		// the kernel fills the checksum of ICMPv6
+		// messages, it covers the IPv6 pseudo-header
+		request = icmpv6EchoRequest
+	}
+	p := &echo{dst: dst, data: payload(opts.Size), done: make(chan echoResult, 1)}
+
+	e.lock.Lock()
+	if e.err != nil {
+		e.lock.Unlock()
+		return Reply{}, e.err
+	}
+	key, ok := e.nextKey()
+	if !ok {
+		e.lock.Unlock()
+		return Reply{}, ErrBusy
+	}
+	p.key = key
+	p.sent = time.Now()
+	p.deadline = p.sent.Add(timeout)
+	e.pending[p.key] = p
+	heap.Push(&e.timeouts, p)
+	first := e.timeouts[0] == p
+	e.lock.Unlock()
+
+	if first {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	b, _ := (&icmpMessage{
+		Type: request,
+		Code: 0,
+		Body: &icmpEcho{
+			ID: p.key.id, Seq: p.key.seq,
+			Data: p.data,
+		},
+	}).Marshal()
+
+	h := Header{TTL: opts.TTL, TOS: opts.TOS, DontFragment: opts.DontFragment}
+	if err := e.conn.WriteTo(b, dst, h); err != nil {
+		e.forget(p)
+		return Reply{}, err
+	}
+
+	select {
+	case r := <-p.done:
+		return r.reply, r.err
+	case <-ctx.Done():
+		e.forget(p)
+		return Reply{}, ctx.Err()
+	}
+}
+
+// payload returns the data of an echo of size bytes, the
+// pattern repeated, DefaultSize bytes if size is not set
+func payload(size int) []byte {
+	const pattern = "ping.gg."
+	if size <= 0 {
+		size = DefaultSize
+	}
+
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = pattern[i%len(pattern)]
+	}
+	return b
+}
+
+// nextKey returns an identifier and sequence pair not in use,
+// false if all are, must be called holding e.lock.
+func (e *Engine) nextKey() (echoKey, bool) {
+	// datagram sockets only have the sequence
+	space := uint64(1) << 32
+	if e.datagram {
+		space = 1 << 16
+	}
+	if uint64(len(e.pending)) >= space {
+		return echoKey{}, false
+	}
+
+	// the counter goes through the whole space before repeating a pair
+	for i := uint64(0); i < space; i++ {
+		n := e.next
+		e.next++
+		key := echoKey{id: (e.base + int(n>>16)) & 0xffff, seq: int(n & 0xffff)}
+		if e.datagram {
+			key.id = 0
+		}
+		if _, used := e.pending[key]; !used {
+			return key, true
+		}
+	}
+	return echoKey{}, false
+}
+
+// forget stops waiting for the reply of p
+func (e *Engine) forget(p *echo) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.pending[p.key] == p {
+		delete(e.pending, p.key)
+		heap.Remove(&e.timeouts, p.index)
+	}
+}
+
+// finish removes p from the pending echoes and returns
+// its result, must be called holding e.lock.
+func (e *Engine) finish(p *echo, reply Reply, err error) {
+	delete(e.pending, p.key)
+	heap.Remove(&e.timeouts, p.index)
+	p.done <- echoResult{reply, err}
+}
+
+// read matches the replies and errors read from the socket to the
+// pending echoes, the ones not matching any are skipped and counted
+func (e *Engine) read() {
+	b := make([]byte, 1<<16)
+	for {
+		n, ttl, src, err := e.conn.ReadFrom(b)
+		if err != nil {
+			e.stop(err)
+			return
+		}
+		now := time.Now()
+
+		// the kernel checks the ICMPv6 checksums
+		v6 := src.To4() == nil
+		if !v6 && !validChecksum(b[:n]) {
+			e.count(&e.counters.Corrupted)
+			continue
+		}
+		m, err := parseICMPMessage(b[:n], v6)
+		if err != nil {
+			e.count(&e.counters.Corrupted)
+			continue
+		}
+
+		// other ICMP messages reach the socket too,
+		// like the echoes of other processes
+		switch body := m.Body.(type) {
+		case *icmpEcho:
+			if m.Type != icmpv4EchoReply && m.Type != icmpv6EchoReply {
+				continue
+			}
+
+			e.lock.Lock()
+			switch p := e.lookup(body.ID, body.Seq, src); {
+			case p == nil:
+				e.counters.Foreign++
+			case !bytes.Equal(body.Data, p.data):
+				e.counters.Corrupted++
+			default:
+				e.counters.Replies++
+				e.finish(p, Reply{Addr: &net.IPAddr{IP: src}, RTT: now.Sub(p.sent), TTL: ttl, Redirect: p.redirect}, nil)
+			}
+			e.lock.Unlock()
+
+		case *icmpError:
+			dst, id, seq, ok := body.echo(v6)
+			if !ok {
+				continue
+			}
+
+			e.lock.Lock()
+			switch p := e.lookup(id, seq, dst); {
+			case p == nil:
+				e.counters.Foreign++
+			case !v6 && m.Type == icmpv4Redirect:
+				// the router still forwards the echo
+				p.redirect = body.gateway()
+			default:
+				e.counters.Errors++
+				e.finish(p, Reply{Addr: &net.IPAddr{IP: src}, RTT: now.Sub(p.sent)}, body.classify(m.Type, m.Code, src, v6))
+			}
+			e.lock.Unlock()
+		}
+	}
+}
+
+// lookup returns the pending echo with the identifier and sequence
+// sent to dst, nil if none, must be called holding e.lock.
+func (e *Engine) lookup(id, seq int, dst net.IP) *echo {
+	key := echoKey{id, seq}
+	if e.datagram {
+		key.id = 0
+	}
+
+	if p, ok := e.pending[key]; ok && p.dst.Equal(dst) {
+		return p
+	}
+	return nil
+}
+
+// count increments one of the counters
+func (e *Engine) count(counter *uint64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	*counter++
+}
+
+// Counters returns the counts of the messages read by the engine
+func (e *Engine) Counters() Counters {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.counters
+}
+
+// expire fails the echoes past their deadline until the engine stops
+func (e *Engine) expire() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-e.wake:
+		case <-timer.C:
+		}
+
+		e.lock.Lock()
+		if e.err != nil {
+			e.lock.Unlock()
+			return
+		}
+		now := time.Now()
+		for len(e.timeouts) > 0 && !e.timeouts[0].deadline.After(now) {
+			e.finish(e.timeouts[0], Reply{}, ErrNoReply)
+		}
+		next := time.Hour
+		if len(e.timeouts) > 0 {
+			next = e.timeouts[0].deadline.Sub(now)
+		}
+		e.lock.Unlock()
+
+		timer.Reset(next)
+	}
+}
+
+// stop fails all the pending echoes and the following ones with err
+func (e *Engine) stop(err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.err != nil {
+		return
+	}
+	e.err = err
+	for len(e.timeouts) > 0 {
+		e.finish(e.timeouts[0], Reply{}, err)
+	}
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stopped tells whether the socket has failed or been closed
+func (e *Engine) stopped() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.err != nil
+}
+
+// echoHeap orders the pending echoes by deadline
+type echoHeap []*echo
+
+func (h echoHeap) Len() int           { return len(h) }
+func (h echoHeap) Less(i, j int) bool { return h[i].deadline.Before(h[j].deadline) }
+
+func (h echoHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *echoHeap) Push(x any) {
+	p := x.(*echo)
+	p.index = len(*h)
+	*h = append(*h, p)
+}
+
+func (h *echoHeap) Pop() any {
+	old := *h
+	p := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return p
+}
@@ -0,0 +1,289 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConn is a network where the hosts reply after their delay,
+// or never if they have none, echoes sent to them are recorded.
+type fakeConn struct {
+	lock    sync.Mutex
+	delays  map[string]time.Duration
+	sent    []net.IP
+	headers []Header
+	replies chan fakePacket
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type fakePacket struct {
+	b   []byte
+	src net.IP
+}
+
+func newFakeConn(delays map[string]time.Duration) *fakeConn {
+	return &fakeConn{delays: delays, replies: make(chan fakePacket, 100), closed: make(chan struct{})}
+}
+
+func (c *fakeConn) ReadFrom(b []byte) (int, int, net.IP, error) {
+	select {
+	case p := <-c.replies:
+		return copy(b, p.b), 64, p.src, nil
+	case <-c.closed:
+		return 0, 0, nil, net.ErrClosed
+	}
+}
+
+func (c *fakeConn) WriteTo(b []byte, dst net.IP, h Header) error {
+	c.lock.Lock()
+	c.sent = append(c.sent, dst)
+	c.headers = append(c.headers, h)
+	delay, ok := c.delays[dst.String()]
+	c.lock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	m, _ := parseICMPMessage(b, dst.To4() == nil)
+	if m.Type == icmpv6EchoRequest {
+		m.Type = icmpv6EchoReply
+	} else {
+		m.Type = icmpv4EchoReply
+	}
+	reply, _ := m.Marshal()
+	time.AfterFunc(delay, func() { c.replies <- fakePacket{reply, dst} })
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// pendingKey returns the identifier and sequence of a pending echo
+func pendingKey(e *Engine) echoKey {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for key := range e.pending {
+		return key
+	}
+	return echoKey{}
+}
+
+// inject sends a packet as if it came from src
+func (c *fakeConn) inject(m *icmpMessage, src string) {
+	b, _ := m.Marshal()
+	c.replies <- fakePacket{b, net.ParseIP(src)}
+}
+
+func TestEngine(t *testing.T) {
+	conn := newFakeConn(map[string]time.Duration{
+		"10.0.0.1":    30 * time.Millisecond,
+		"10.0.0.2":    10 * time.Millisecond,
+		"2001:db8::1": 20 * time.Millisecond,
+	})
+	e := NewEngine(conn)
+	defer e.Close()
+
+	type result struct {
+		host  string
+		reply Reply
+		err   error
+	}
+	results := make(chan result, 4)
+	for _, host := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "2001:db8::1"} {
+		go func(host string) {
+			reply, err := e.Echo(context.Background(), net.ParseIP(host), Options{Timeout: 100 * time.Millisecond})
+			results <- result{host, reply, err}
+		}(host)
+	}
+
+	for i := 0; i < 4; i++ {
+		r := <-results
+		switch r.host {
+		case "10.0.0.3":
+			if r.err != ErrNoReply {
+				t.Errorf("Got error: %v for %s, expected: %v", r.err, r.host, ErrNoReply)
+			}
+		default:
+			if r.err != nil || r.reply.Addr.String() != r.host || r.reply.TTL != 64 {
+				t.Errorf("Got reply: %+v error: %v for %s, expected its own reply", r.reply, r.err, r.host)
+			}
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.pending) != 0 || len(e.timeouts) != 0 {
+		t.Errorf("Got %d echoes pending, expected none", len(e.pending))
+	}
+}
+
+func TestEngineForeignReply(t *testing.T) {
+	conn := newFakeConn(nil)
+	e := NewEngine(conn)
+	defer e.Close()
+
+	errCh := make(chan error)
+	go func() {
+		_, err := e.Echo(context.Background(), net.ParseIP("10.0.0.1"), Options{Timeout: 50 * time.Millisecond})
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// the same identifier and sequence from another host, and the request itself
+	key := pendingKey(e)
+	conn.inject(&icmpMessage{Type: icmpv4EchoReply, Body: &icmpEcho{ID: key.id, Seq: key.seq}}, "10.0.0.2")
+	conn.inject(&icmpMessage{Type: icmpv4EchoRequest, Body: &icmpEcho{ID: key.id, Seq: key.seq}}, "10.0.0.1")
+
+	if err := <-errCh; err != ErrNoReply {
+		t.Errorf("Got error: %v, expected: %v", err, ErrNoReply)
+	}
+}
+
+func TestEngineCancel(t *testing.T) {
+	e := NewEngine(newFakeConn(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := e.Echo(ctx, net.ParseIP("10.0.0.1"), Options{Timeout: time.Hour}); err != context.DeadlineExceeded {
+		t.Errorf("Got error: %v, expected: %v", err, context.DeadlineExceeded)
+	}
+
+	errCh := make(chan error)
+	go func() {
+		_, err := e.Echo(context.Background(), net.ParseIP("10.0.0.1"), Options{Timeout: time.Hour})
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	e.Close()
+
+	if err := <-errCh; err != ErrClosed {
+		t.Errorf("Got error: %v, expected: %v", err, ErrClosed)
+	}
+	if _, err := e.Echo(context.Background(), net.ParseIP("10.0.0.1"), Options{Timeout: time.Hour}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Got error: %v, expected: %v", err, ErrClosed)
+	}
+}
+
+func TestEngineValidation(t *testing.T) {
+	conn := newFakeConn(nil)
+	e := NewEngine(conn)
+	defer e.Close()
+
+	errCh := make(chan error)
+	go func() {
+		_, err := e.Echo(context.Background(), net.ParseIP("10.0.0.1").To4(), Options{Timeout: 100 * time.Millisecond})
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	key := pendingKey(e)
+
+	// a wrong payload, a wrong checksum and a spoofed source
+	conn.inject(&icmpMessage{Type: icmpv4EchoReply, Body: &icmpEcho{ID: key.id, Seq: key.seq, Data: []byte("spoofed")}}, "10.0.0.1")
+	b, _ := (&icmpMessage{Type: icmpv4EchoReply, Body: &icmpEcho{ID: key.id, Seq: key.seq, Data: []byte("ping.gg.ping.gg.ping.gg")}}).Marshal()
+	b[2] ^= 0xff
+	conn.replies <- fakePacket{b, net.ParseIP("10.0.0.1")}
+	conn.inject(&icmpMessage{Type: icmpv4EchoReply, Body: &icmpEcho{ID: key.id, Seq: key.seq, Data: []byte("ping.gg.ping.gg.ping.gg")}}, "10.0.0.2")
+
+	if err := <-errCh; err != ErrNoReply {
+		t.Errorf("Got error: %v, expected: %v", err, ErrNoReply)
+	}
+	if c := e.Counters(); c != (Counters{Corrupted: 2, Foreign: 1}) {
+		t.Errorf("Got counters: %+v, expected 2 corrupted and 1 foreign", c)
+	}
+}
+
+func TestValidChecksum(t *testing.T) {
+	for _, data := range []string{"", "odd", "ping.gg.ping.gg.ping.gg"} {
+		b, _ := (&icmpMessage{Type: icmpv4EchoRequest, Body: &icmpEcho{ID: 0x1234, Seq: 1, Data: []byte(data)}}).Marshal()
+		if !validChecksum(b) {
+			t.Errorf("Got wrong checksum for payload %q, expected right", data)
+		}
+		b[len(b)-1] ^= 1
+		if validChecksum(b) {
+			t.Errorf("Got right checksum for corrupted payload %q, expected wrong", data)
+		}
+	}
+}
+
+func TestEngineStats(t *testing.T) {
+	conn := newFakeConn(map[string]time.Duration{"10.0.0.1": time.Millisecond})
+	e := NewEngine(conn)
+	defer e.Close()
+
+	stats, err := e.Stats(context.Background(), net.ParseIP("10.0.0.1"), Options{Count: 3, Interval: 5 * time.Millisecond})
+	if err != nil || stats.Sent != 3 || stats.Received != 3 || stats.Loss != 0 || stats.Min < time.Millisecond {
+		t.Errorf("Got stats: %+v error: %v, expected 3 replies", stats, err)
+	}
+
+	TimeOut = 20 * time.Millisecond
+	if _, err := e.Stats(context.Background(), net.ParseIP("10.0.0.2"), Options{Count: 2, Interval: time.Millisecond}); err != ErrNoReply {
+		t.Errorf("Got error: %v, expected: %v", err, ErrNoReply)
+	}
+}
+
+// fakeDatagramConn is a fake network where the kernel sets the echo identifiers
+type fakeDatagramConn struct {
+	*fakeConn
+}
+
+func (c fakeDatagramConn) WriteTo(b []byte, dst net.IP, h Header) error {
+	b = append([]byte(nil), b...)
+	b[4], b[5] = 0x10, 0x92 // the port of the socket
+	return c.fakeConn.WriteTo(b, dst, h)
+}
+
+func (c fakeDatagramConn) datagram() {}
+
+func TestEngineDatagram(t *testing.T) {
+	e := NewEngine(fakeDatagramConn{newFakeConn(map[string]time.Duration{"10.0.0.1": time.Millisecond})})
+	defer e.Close()
+
+	if _, err := e.Echo(context.Background(), net.ParseIP("10.0.0.1"), Options{Timeout: time.Second}); err != nil {
+		t.Errorf("Got error: %v, expected the reply matched by sequence", err)
+	}
+
+	// every sequence taken
+	e.lock.Lock()
+	for seq := 0; seq < 1<<16; seq++ {
+		e.pending[echoKey{seq: seq}] = &echo{}
+	}
+	e.lock.Unlock()
+	if _, err := e.Echo(context.Background(), net.ParseIP("10.0.0.1"), Options{Timeout: time.Second}); err != ErrBusy {
+		t.Errorf("Got error: %v, expected: %v", err, ErrBusy)
+	}
+}
+
+func TestEngineOptions(t *testing.T) {
+	conn := newFakeConn(map[string]time.Duration{"10.0.0.1": time.Millisecond})
+	e := NewEngine(conn)
+	defer e.Close()
+
+	h := Header{TTL: 3, TOS: 184, DontFragment: true}
+	opts := Options{Timeout: time.Second, Size: 1400, TTL: h.TTL, TOS: h.TOS, DontFragment: h.DontFragment}
+	if _, err := e.Echo(context.Background(), net.ParseIP("10.0.0.1"), opts); err != nil {
+		t.Errorf("Got error: %v, expected the reply echoing the payload", err)
+	}
+
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.headers[0] != h {
+		t.Errorf("Got header: %+v, expected: %+v", conn.headers[0], h)
+	}
+}
+
+func TestPayload(t *testing.T) {
+	if p := string(payload(0)); p != "ping.gg.ping.gg.ping.gg" {
+		t.Errorf("Got payload: %q, expected the default one", p)
+	}
+	if p := payload(1000); len(p) != 1000 || p[999] != '.' {
+		t.Errorf("Got payload of %d bytes, expected 1000 of the pattern", len(p))
+	}
+}
@@ -0,0 +1,180 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Reasons of the ICMP errors sent back by the routers on the path, or
+// the host itself, instead of a reply, see ICMPError
+var (
+	ErrNetUnreachable      = errors.New("network unreachable")
+	ErrHostUnreachable     = errors.New("host unreachable")
+	ErrProtocolUnreachable = errors.New("protocol unreachable")
+	ErrPortUnreachable     = errors.New("port unreachable")
+	ErrAdminProhibited     = errors.New("administratively prohibited")
+	ErrFragmentationNeeded = errors.New("fragmentation needed")
+	ErrUnreachable         = errors.New("destination unreachable") // for the less common codes
+	ErrTTLExceeded         = errors.New("ttl exceeded in transit")
+	ErrReassemblyExceeded  = errors.New("fragment reassembly time exceeded")
+)
+
+// ICMP error message types
+const (
+	icmpv4Unreachable  = 3
+	icmpv4Redirect     = 5
+	icmpv4TimeExceeded = 11
+	icmpv6Unreachable  = 1
+	icmpv6PacketTooBig = 2
+	icmpv6TimeExceeded = 3
+)
+
+// ICMPError is an ICMP error message received instead of the reply
+// to an echo, errors.Is tells its reason, eg: ErrHostUnreachable
+type ICMPError struct {
+	Err  error  // reason
+	From net.IP // router which sent it
+	Type int
+	Code int
+	MTU  int // of the next hop, with ErrFragmentationNeeded
+}
+
+func (e *ICMPError) Error() string {
+	if e.MTU > 0 {
+		return fmt.Sprintf("%s (mtu %d) from %s", e.Err, e.MTU, e.From)
+	}
+	return fmt.Sprintf("%s from %s", e.Err, e.From)
+}
+
+func (e *ICMPError) Unwrap() error {
+	return e.Err
+}
+
+// icmpError represents the body of an ICMP error message,
+// with the start of the packet which caused it
+type icmpError struct {
+	Rest     [4]byte // MTU or gateway depending on the type
+	Original []byte  // IP header and the first bytes of its payload
+}
+
+func (p *icmpError) Len() int {
+	if p == nil {
+		return 0
+	}
+	return 4 + len(p.Original)
+}
+
+// Marshal returns the binary encoding of the ICMP error message body p.
+func (p *icmpError) Marshal() ([]byte, error) {
+	return append(p.Rest[:], p.Original...), nil
+}
+
+// parseICMPError parses b as an ICMP error message body.
+func parseICMPError(b []byte) (*icmpError, error) {
+	if len(b) < 4 {
+		return nil, errors.New("error message too short")
+	}
+	p := &icmpError{Original: make([]byte, len(b)-4)}
+	copy(p.Rest[:], b)
+	copy(p.Original, b[4:])
+	return p, nil
+}
+
+// isICMPError tells whether the type is of an error message of the family
+func isICMPError(typ int, v6 bool) bool {
+	if v6 {
+		return typ == icmpv6Unreachable || typ == icmpv6PacketTooBig || typ == icmpv6TimeExceeded
+	}
+	return typ == icmpv4Unreachable || typ == icmpv4Redirect || typ == icmpv4TimeExceeded
+}
+
+// echo returns the destination, identifier and sequence of the echo
+// request which caused the error, ok is false if it wasn't one.
+func (p *icmpError) echo(v6 bool) (dst net.IP, id, seq int, ok bool) {
+	b := p.Original
+	if v6 {
+		// extension headers are not expected on echoes
+		if len(b) < 48 || b[0]>>4 != 6 || b[6] != 58 {
+			return nil, 0, 0, false
+		}
+		dst, b = net.IP(b[24:40]), b[40:]
+		if b[0] != icmpv6EchoRequest {
+			return nil, 0, 0, false
+		}
+	} else {
+		if len(b) < 20 || b[0]>>4 != 4 || b[9] != 1 {
+			return nil, 0, 0, false
+		}
+		hdrlen := int(b[0]&0x0f) << 2
+		if len(b) < hdrlen+8 {
+			return nil, 0, 0, false
+		}
+		dst, b = net.IP(b[16:20]), b[hdrlen:]
+		if b[0] != icmpv4EchoRequest {
+			return nil, 0, 0, false
+		}
+	}
+
+	return dst, int(b[4])<<8 | int(b[5]), int(b[6])<<8 | int(b[7]), true
+}
+
+// classify returns the error telling why the echo failed
+func (p *icmpError) classify(typ, code int, from net.IP, v6 bool) *ICMPError {
+	e := &ICMPError{Err: ErrUnreachable, From: from, Type: typ, Code: code}
+
+	if v6 {
+		switch typ {
+		case icmpv6Unreachable:
+			switch code {
+			case 0, 2: // no route, beyond the scope of the source
+				e.Err = ErrNetUnreachable
+			case 1, 5, 6: // prohibited, source policy, reject route
+				e.Err = ErrAdminProhibited
+			case 3:
+				e.Err = ErrHostUnreachable
+			case 4:
+				e.Err = ErrPortUnreachable
+			}
+		case icmpv6PacketTooBig:
+			e.Err = ErrFragmentationNeeded
+			e.MTU = int(p.Rest[0])<<24 | int(p.Rest[1])<<16 | int(p.Rest[2])<<8 | int(p.Rest[3])
+		case icmpv6TimeExceeded:
+			e.Err = ErrTTLExceeded
+			if code == 1 {
+				e.Err = ErrReassemblyExceeded
+			}
+		}
+		return e
+	}
+
+	switch typ {
+	case icmpv4Unreachable:
+		switch code {
+		case 0, 6, 11: // net, unknown net, net for TOS
+			e.Err = ErrNetUnreachable
+		case 1, 7, 12: // host, unknown host, host for TOS
+			e.Err = ErrHostUnreachable
+		case 2:
+			e.Err = ErrProtocolUnreachable
+		case 3:
+			e.Err = ErrPortUnreachable
+		case 4:
+			e.Err = ErrFragmentationNeeded
+			e.MTU = int(p.Rest[2])<<8 | int(p.Rest[3])
+		case 9, 10, 13: // net, host, communication prohibited
+			e.Err = ErrAdminProhibited
+		}
+	case icmpv4TimeExceeded:
+		e.Err = ErrTTLExceeded
+		if code == 1 {
+			e.Err = ErrReassemblyExceeded
+		}
+	}
+	return e
+}
+
+// gateway returns the router a redirect message points to
+func (p *icmpError) gateway() net.IP {
+	return net.IP(p.Rest[:])
+}
@@ -0,0 +1,130 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// quote returns the start of the IPv4 packet of an echo request
+func quote(dst string, id, seq int) []byte {
+	b := make([]byte, 20, 28)
+	b[0], b[9] = 0x45, 1
+	copy(b[16:20], net.ParseIP(dst).To4())
+	echo, _ := (&icmpMessage{Type: icmpv4EchoRequest, Body: &icmpEcho{ID: id, Seq: seq}}).Marshal()
+	return append(b, echo[:8]...)
+}
+
+// quote6 returns the start of the IPv6 packet of an echo request
+func quote6(dst string, id, seq int) []byte {
+	b := make([]byte, 40, 48)
+	b[0], b[6] = 0x60, 58
+	copy(b[24:40], net.ParseIP(dst))
+	echo, _ := (&icmpMessage{Type: icmpv6EchoRequest, Body: &icmpEcho{ID: id, Seq: seq}}).Marshal()
+	return append(b, echo[:8]...)
+}
+
+var classifytests = []struct {
+	typ, code int
+	rest      [4]byte
+	v6        bool
+	err       error
+	mtu       int
+}{
+	{icmpv4Unreachable, 0, [4]byte{}, false, ErrNetUnreachable, 0},
+	{icmpv4Unreachable, 1, [4]byte{}, false, ErrHostUnreachable, 0},
+	{icmpv4Unreachable, 3, [4]byte{}, false, ErrPortUnreachable, 0},
+	{icmpv4Unreachable, 4, [4]byte{0, 0, 0x05, 0x78}, false, ErrFragmentationNeeded, 1400},
+	{icmpv4Unreachable, 13, [4]byte{}, false, ErrAdminProhibited, 0},
+	{icmpv4Unreachable, 8, [4]byte{}, false, ErrUnreachable, 0},
+	{icmpv4TimeExceeded, 0, [4]byte{}, false, ErrTTLExceeded, 0},
+	{icmpv6Unreachable, 0, [4]byte{}, true, ErrNetUnreachable, 0},
+	{icmpv6Unreachable, 1, [4]byte{}, true, ErrAdminProhibited, 0},
+	{icmpv6Unreachable, 3, [4]byte{}, true, ErrHostUnreachable, 0},
+	{icmpv6PacketTooBig, 0, [4]byte{0, 0, 0x05, 0x00}, true, ErrFragmentationNeeded, 1280},
+	{icmpv6TimeExceeded, 0, [4]byte{}, true, ErrTTLExceeded, 0},
+	{icmpv6TimeExceeded, 1, [4]byte{}, true, ErrReassemblyExceeded, 0},
+}
+
+func TestClassify(t *testing.T) {
+	from := net.ParseIP("192.0.2.254")
+	for _, tt := range classifytests {
+		e := (&icmpError{Rest: tt.rest}).classify(tt.typ, tt.code, from, tt.v6)
+		if e.Err != tt.err || e.MTU != tt.mtu {
+			t.Errorf("Got error: %v for type %d code %d, expected: %v", e, tt.typ, tt.code, tt.err)
+		}
+	}
+
+	e := (&icmpError{}).classify(icmpv4Unreachable, 1, from, false)
+	if !errors.Is(e, ErrHostUnreachable) || e.Error() != "host unreachable from 192.0.2.254" {
+		t.Errorf("Got error: %q, expected host unreachable from the router", e)
+	}
+}
+
+func TestErrorEcho(t *testing.T) {
+	m, err := parseICMPMessage(append([]byte{icmpv6TimeExceeded, 0, 0, 0, 0, 0, 0, 0}, quote6("2001:db8::1", 7, 9)...), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, ok := m.Body.(*icmpError)
+	if !ok {
+		t.Fatalf("Got body: %T, expected an error", m.Body)
+	}
+	if dst, id, seq, ok := body.echo(true); !ok || dst.String() != "2001:db8::1" || id != 7 || seq != 9 {
+		t.Errorf("Got echo to: %s id: %d seq: %d, expected the quoted one", dst, id, seq)
+	}
+
+	// the same type is a destination unreachable on ICMP
+	if m, _ := parseICMPMessage(append([]byte{icmpv4Unreachable, 1, 0, 0, 0, 0, 0, 0}, quote("192.0.2.1", 7, 9)...), false); m.Body == nil {
+		t.Errorf("Got no body, expected an error")
+	}
+}
+
+func TestEngineICMPError(t *testing.T) {
+	conn := newFakeConn(nil)
+	e := NewEngine(conn)
+	defer e.Close()
+
+	errCh := make(chan error)
+	go func() {
+		_, err := e.Echo(context.Background(), net.ParseIP("10.0.0.1").To4(), Options{Timeout: time.Second})
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	key := pendingKey(e)
+
+	// an error about another echo is ignored
+	conn.inject(&icmpMessage{Type: icmpv4Unreachable, Code: 1, Body: &icmpError{Original: quote("10.0.0.2", key.id, key.seq)}}, "10.0.0.254")
+	conn.inject(&icmpMessage{Type: icmpv4Unreachable, Code: 1, Body: &icmpError{Original: quote("10.0.0.1", key.id, key.seq)}}, "10.0.0.254")
+
+	err := <-errCh
+	var icmpErr *ICMPError
+	if !errors.As(err, &icmpErr) || icmpErr.Err != ErrHostUnreachable || !icmpErr.From.Equal(net.ParseIP("10.0.0.254")) {
+		t.Errorf("Got error: %v, expected host unreachable from the router", err)
+	}
+}
+
+func TestEngineRedirect(t *testing.T) {
+	conn := newFakeConn(nil)
+	e := NewEngine(conn)
+	defer e.Close()
+
+	replyCh := make(chan Reply)
+	go func() {
+		reply, _ := e.Echo(context.Background(), net.ParseIP("10.0.0.1").To4(), Options{Timeout: time.Second})
+		replyCh <- reply
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	key := pendingKey(e)
+
+	conn.inject(&icmpMessage{Type: icmpv4Redirect, Code: 1, Body: &icmpError{Rest: [4]byte{10, 0, 0, 253}, Original: quote("10.0.0.1", key.id, key.seq)}}, "10.0.0.254")
+	conn.inject(&icmpMessage{Type: icmpv4EchoReply, Body: &icmpEcho{ID: key.id, Seq: key.seq, Data: []byte("ping.gg.ping.gg.ping.gg")}}, "10.0.0.1")
+
+	if reply := <-replyCh; reply.Redirect.String() != "10.0.0.253" {
+		t.Errorf("Got reply: %+v, expected redirected to the gateway", reply)
+	}
+}
@@ -0,0 +1,138 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DefaultMaxMTU is the largest packet tried by PingMTU when max is not set
+const DefaultMaxMTU = 1500
+
+// Smallest MTU of each address family, every path is expected to
+// carry packets this big so the host is down if they get no reply
+const (
+	minMTU4 = 68
+	minMTU6 = 1280
+)
+
+// MTU is the outcome of a path MTU discovery
+type MTU struct {
+	Addr   net.Addr      // address that replied
+	Size   int           // largest packet reaching the host, IP header included
+	From   net.IP        // last router which told fragmentation was needed, nil if none
+	RTT    time.Duration // round-trip time of the last reply
+	Probes int           // echoes sent
+}
+
+// PingMTU finds the path MTU to a given host, the largest packet up to max
+// bytes reaching it without being fragmented, see Engine.MTU.
+func PingMTU(ctx context.Context, host string, max int, opts Options) (MTU, error) {
+	dst, err := resolve(ctx, host, opts.Family)
+	if err != nil {
+		return MTU{}, err
+	}
+
+	e, err := defaultEngine(dst, opts.Source)
+	if err != nil {
+		return MTU{}, err
+	}
+
+	return e.MTU(ctx, dst, max, opts)
+}
+
+// MTU finds the path MTU to dst with a binary search of echoes with the
+// don't fragment bit set, up to max bytes, DefaultMaxMTU if not set. The
+// sizes the routers tell with fragmentation needed errors are tried next,
+// sizes over the MTU of the interface fail without being sent, and sizes
+// without reply after opts.Count echoes are taken as too big, dropped by
+// a black hole. The payload size and don't fragment bit of opts are not
+// used. The error is only set when even the smallest MTU gets no reply,
+// if ctx is done during the search the largest size which fit so far is
+// returned, so a deadline too short for the echoes lost on a black hole
+// understates the MTU instead of failing.
+func (e *Engine) MTU(ctx context.Context, dst net.IP, max int, opts Options) (MTU, error) {
+	header, min := 20+8, minMTU4
+	if dst.To4() == nil {
+		header, min = 40+8, minMTU6
+	}
+	if max <= 0 {
+		max = DefaultMaxMTU
+	}
+	if max < min {
+		max = min
+	}
+	tries := opts.Count
+	if tries <= 0 {
+		tries = 1
+	}
+	opts.DontFragment = true
+
+	m := MTU{Addr: &net.IPAddr{IP: dst}}
+	hint := 0
+	// fits tells whether an echo of size bytes gets a reply
+	fits := func(size int) (bool, error) {
+		opts.Size = size - header
+		for i := 0; i < tries; i++ {
+			m.Probes++
+			reply, err := e.Echo(ctx, dst, opts)
+			var icmpErr *ICMPError
+			switch {
+			case err == nil:
+				m.Addr, m.RTT = reply.Addr, reply.RTT
+				return true, nil
+			case errors.As(err, &icmpErr) && icmpErr.Err == ErrFragmentationNeeded:
+				m.From = icmpErr.From
+				if icmpErr.MTU < size {
+					hint = icmpErr.MTU
+				}
+				return false, nil
+			case errors.Is(err, syscall.EMSGSIZE):
+				// over the MTU of the interface
+				return false, nil
+			case err != ErrNoReply:
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	ok, err := fits(min)
+	if err == nil && !ok {
+		err = ErrNoReply
+	}
+	if err != nil {
+		return m, err
+	}
+
+	// the whole range is tried first as it's the most common MTU
+	lo, hi, next := min, max, max
+	for lo < hi {
+		hint = 0
+		ok, err := fits(next)
+		if err != nil && ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			return m, err
+		}
+
+		switch {
+		case ok:
+			lo = next
+		case hint > lo:
+			hi = hint
+		default:
+			hi = next - 1
+		}
+		next = (lo + hi + 1) / 2
+		if hint > lo && hint == hi {
+			next = hi
+		}
+	}
+
+	m.Size = lo
+	return m, nil
+}
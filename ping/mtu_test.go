@@ -0,0 +1,79 @@
+package ping
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeTunnel is a fake network where the echoes over mtu bytes are
+// dropped, or answered with fragmentation needed by router if set
+type fakeTunnel struct {
+	*fakeConn
+	mtu    int
+	router string
+}
+
+func (c fakeTunnel) WriteTo(b []byte, dst net.IP, h Header) error {
+	if 20+len(b) <= c.mtu || !h.DontFragment {
+		return c.fakeConn.WriteTo(b, dst, h)
+	}
+
+	c.lock.Lock()
+	c.sent = append(c.sent, dst)
+	c.lock.Unlock()
+	if c.router != "" {
+		id, seq := int(b[4])<<8|int(b[5]), int(b[6])<<8|int(b[7])
+		rest := [4]byte{0, 0, byte(c.mtu >> 8), byte(c.mtu)}
+		c.inject(&icmpMessage{Type: icmpv4Unreachable, Code: 4, Body: &icmpError{Rest: rest, Original: quote(dst.String(), id, seq)}}, c.router)
+	}
+	return nil
+}
+
+var mtutests = []struct {
+	mtu     int
+	router  string
+	max     int
+	size    int
+	maxSent int
+	timeout time.Duration // of the search, the size is the least expected then
+}{
+	{1500, "", 0, 1500, 2, 0},
+	{1400, "192.0.2.254", 0, 1400, 3, 0},
+	{1400, "", 0, 1400, 13, 0},
+	{9000, "", 0, 1500, 2, 0},
+	{1420, "", 9000, 1420, 16, 0},
+	{1400, "", 0, minMTU4, 13, 12 * time.Millisecond},
+}
+
+func TestEngineMTU(t *testing.T) {
+	defer func(timeout time.Duration) { TimeOut = timeout }(TimeOut)
+	TimeOut = 5 * time.Millisecond
+	for _, tt := range mtutests {
+		conn := newFakeConn(map[string]time.Duration{"10.0.0.1": 0})
+		e := NewEngine(fakeTunnel{conn, tt.mtu, tt.router})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+		}
+		m, err := e.MTU(ctx, net.ParseIP("10.0.0.1").To4(), tt.max, Options{})
+		cancel()
+		exact := tt.timeout == 0 && m.Size == tt.size
+		partial := tt.timeout > 0 && m.Size >= tt.size && m.Size <= tt.mtu
+		if err != nil || !(exact || partial) || m.Probes > tt.maxSent {
+			t.Errorf("Got mtu: %+v error: %v on a %d mtu path, expected %d in at most %d echoes", m, err, tt.mtu, tt.size, tt.maxSent)
+		}
+		if tt.router != "" && m.From.String() != tt.router {
+			t.Errorf("Got mtu from: %s, expected: %s", m.From, tt.router)
+		}
+		e.Close()
+	}
+
+	e := NewEngine(newFakeConn(nil))
+	defer e.Close()
+	if _, err := e.MTU(context.Background(), net.ParseIP("10.0.0.2").To4(), 0, Options{}); err != ErrNoReply {
+		t.Errorf("Got error: %v, expected: %v", err, ErrNoReply)
+	}
+}
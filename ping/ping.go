@@ -6,86 +6,192 @@
 package ping
 
 import (
+	"context"
 	"errors"
-	"log"
+	"math"
 	"net"
-	"os"
 	"time"
 )
 
 // Timeout sets the ping timeout in milliseconds
 var TimeOut = 3000 * time.Millisecond
 
-// Ping sends a ping command to a given host, returns whether is host answers or not
-func Ping(host string) (up bool, err error) {
+// Reply holds the details of an echo reply
+type Reply struct {
+	Addr net.Addr      // address that replied
+	RTT  time.Duration // round-trip time
+	TTL  int           // time to live of the reply, hop limit for IPv6
 
-	// Don't panic, just return nil
-	defer func() {
-		if r := recover(); r != nil {
-			up = false
-			err = r.(error)
-			return
-		}
-	}()
+	// router which told to send the echo to Redirect instead, nil if none
+	Redirect net.IP
+}
 
-	if os.Geteuid() != 0 {
-		log.Fatal("skipping ping, root permissions missing")
-	}
+// Options sets how many echoes are sent per ping and how
+type Options struct {
+	Count    int           // echoes sent, 1 if not set
+	Interval time.Duration // between echoes, DefaultInterval if not set
+	Timeout  time.Duration // for each reply, TimeOut if not set
+	Family   Family        // of the address pinged, AnyFamily if not set
+	Source   string        // address or interface to send from, any if not set
+
+	Size         int  // payload bytes, DefaultSize if not set
+	TTL          int  // see Header, the system default if not set
+	TOS          int  // see Header, the system default if not set
+	DontFragment bool // fail instead of fragmenting echoes over the path MTU
+}
+
+// DefaultInterval is the time between the echoes of a ping
+// sending several when Options.Interval is not set
+const DefaultInterval = time.Second
+
+// DefaultSize is the payload size of the echoes when Options.Size is not set
+const DefaultSize = 23
 
-	c, err := net.Dial("ip:icmp", host)
+// Stats sums up the replies to the echoes of a ping, like ping -c does
+type Stats struct {
+	Addr     net.Addr // address that replied
+	Sent     int
+	Received int
+	Loss     float64       // percentage of echoes without reply
+	Min      time.Duration // round-trip times of the replies
+	Avg      time.Duration
+	Max      time.Duration
+	Mdev     time.Duration // standard deviation of the round-trip times
+	Jitter   time.Duration // mean difference between consecutive round-trip times
+	TTL      int           // time to live of the last reply
+}
+
+// Ping sends a ping command to a given host, returns whether is host answers or not
+func Ping(host string) (up bool, err error) {
+	_, err = PingContext(context.Background(), host)
+	return err == nil, err
+}
+
+// PingContext sends a ping command to a given host and returns the reply,
+// it gives up when ctx is done or after TimeOut, whatever happens first.
+func PingContext(ctx context.Context, host string) (reply Reply, err error) {
+	stats, err := PingStats(ctx, host, Options{Count: 1})
 	if err != nil {
-		return false, err
+		return reply, err
 	}
 
-	c.SetDeadline(time.Now().Add(TimeOut))
-	defer c.Close()
-
-	xid, xseq := os.Getpid()&0xffff, 1
-	b, err := (&icmpMessage{
-		Type: icmpv4EchoRequest,
-		Code: 0,
-		Body: &icmpEcho{
-			ID: xid, Seq: xseq,
-			Data: []byte("ping.gg.ping.gg.ping.gg"),
-		},
-	}).Marshal()
+	return Reply{Addr: stats.Addr, RTT: stats.Avg, TTL: stats.TTL}, nil
+}
 
+// PingStats sends opts.Count echoes to a given host opts.Interval apart
+// and returns the stats of their replies. Each echo waits for its reply
+// until opts.Timeout or ctx is done, whatever happens first. The error is
+// only set when no echo got a reply, an *ICMPError if a router told why.
+func PingStats(ctx context.Context, host string, opts Options) (Stats, error) {
+	dst, err := resolve(ctx, host, opts.Family)
 	if err != nil {
-		return false, err
+		return Stats{}, err
 	}
 
-	if _, err := c.Write(b); err != nil {
-		return false, err
+	e, err := defaultEngine(dst, opts.Source)
+	if err != nil {
+		return Stats{}, err
 	}
 
-	var m *icmpMessage
+	return e.Stats(ctx, dst, opts)
+}
 
-	if _, err := c.Read(b); err != nil {
-		return false, err
+// Stats sends opts.Count echoes to dst opts.Interval apart and returns
+// the stats of their replies, see PingStats
+func (e *Engine) Stats(ctx context.Context, dst net.IP, opts Options) (Stats, error) {
+	count, interval := opts.Count, opts.Interval
+	if count <= 0 {
+		count = 1
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
 	}
 
-	b = ipv4Payload(b)
+	results := make(chan echoResult, count)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	if m, err = parseICMPMessage(b); err != nil {
-		return false, err
+	sent := 0
+	for sent < count {
+		if sent > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		sent++
+		go func() {
+			reply, err := e.Echo(ctx, dst, opts)
+			results <- echoResult{reply, err}
+		}()
 	}
 
-	if m.Type != icmpv4EchoReply && m.Type != icmpv6EchoReply {
-		return false, errors.New("invalid reply type")
+	// the round-trip times in the order the replies arrived
+	var rtts []time.Duration
+	var last echoResult
+	err := ErrNoReply
+	for i := 0; i < sent; i++ {
+		r := <-results
+		if r.err != nil {
+			if r.err != ErrNoReply {
+				err = r.err
+			}
+			continue
+		}
+		rtts = append(rtts, r.reply.RTT)
+		last = r
+	}
+	if len(rtts) == 0 {
+		if ctx.Err() != nil {
+			return Stats{}, ctx.Err()
+		}
+		return Stats{}, err
 	}
 
-	switch p := m.Body.(type) {
-	case *icmpEcho:
-		if p.ID != xid {
-			return false, errors.New("invalid reply indentifier")
+	stats := summarize(rtts, sent)
+	stats.Addr, stats.TTL = last.reply.Addr, last.reply.TTL
+	return stats, nil
+}
+
+// summarize computes the stats of the round-trip
+// times of the replies, in the order they arrived
+func summarize(rtts []time.Duration, sent int) Stats {
+	stats := Stats{Sent: sent, Received: len(rtts)}
+	if sent > 0 {
+		stats.Loss = 100 * float64(sent-len(rtts)) / float64(sent)
+	}
+	if len(rtts) == 0 {
+		return stats
+	}
+
+	var sum, sum2, diffs float64
+	stats.Min, stats.Max = rtts[0], rtts[0]
+	for i, rtt := range rtts {
+		if rtt < stats.Min {
+			stats.Min = rtt
 		}
-		if p.Seq != xseq {
-			return false, errors.New("invalid reply sequence")
+		if rtt > stats.Max {
+			stats.Max = rtt
 		}
-		return true, nil // UP!
-	default:
-		return false, errors.New("invalid reply payload")
+		sum += float64(rtt)
+		sum2 += float64(rtt) * float64(rtt)
+		if i > 0 {
+			diffs += math.Abs(float64(rtt - rtts[i-1]))
+		}
+	}
+
+	n := float64(len(rtts))
+	avg := sum / n
+	stats.Avg = time.Duration(avg)
+	stats.Mdev = time.Duration(math.Sqrt(math.Max(sum2/n-avg*avg, 0)))
+	if len(rtts) > 1 {
+		stats.Jitter = time.Duration(diffs / (n - 1))
 	}
+
+	return stats
 }
 
 func ipv4Payload(b []byte) []byte {
@@ -149,8 +255,23 @@ func (m *icmpMessage) Marshal() ([]byte, error) {
 	return b, nil
 }
 
-// parseICMPMessage parses b as an ICMP message.
-func parseICMPMessage(b []byte) (*icmpMessage, error) {
+// validChecksum tells whether the checksum of the ICMP message b is right
+func validChecksum(b []byte) bool {
+	s := uint32(0)
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)&1 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	s = s>>16 + s&0xffff
+	s = s + s>>16
+	return uint16(s) == 0xffff
+}
+
+// parseICMPMessage parses b as an ICMP message of the family,
+// error messages types are not the same on ICMPv6.
+func parseICMPMessage(b []byte, v6 bool) (*icmpMessage, error) {
 	msglen := len(b)
 	if msglen < 4 {
 		return nil, errors.New("message too short")
@@ -164,6 +285,12 @@ func parseICMPMessage(b []byte) (*icmpMessage, error) {
 			if err != nil {
 				return nil, err
 			}
+		default:
+			if isICMPError(m.Type, v6) {
+				if m.Body, err = parseICMPError(b[4:]); err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
 	return m, nil
@@ -197,6 +324,9 @@ func (p *icmpEcho) Marshal() ([]byte, error) {
 // body.
 func parseICMPEcho(b []byte) (*icmpEcho, error) {
 	bodylen := len(b)
+	if bodylen < 4 {
+		return nil, errors.New("echo too short")
+	}
 	p := &icmpEcho{ID: int(b[0])<<8 | int(b[1]), Seq: int(b[2])<<8 | int(b[3])}
 	if bodylen > 4 {
 		p.Data = make([]byte, bodylen-4)
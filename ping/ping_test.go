@@ -13,8 +13,8 @@ var pingtests = []struct {
 	{"127.0.0.1", true, ""},
 	{"8.8.8.8", true, ""},
 	{"google.com", true, ""},
-	{"128.0.0.1", false, "read ip 128.0.0.1: i/o timeout"},
-	{"fail.ping.gg", false, "dial ip:icmp: lookup fail.ping.gg: no such host"},
+	{"128.0.0.1", false, "no echo reply"},
+	{"fail.ping.gg", false, "lookup fail.ping.gg: no such host"},
 }
 
 func TestPing(t *testing.T) {
@@ -36,3 +36,26 @@ func TestPing(t *testing.T) {
 		}
 	}
 }
+
+func TestSummarize(t *testing.T) {
+	ms := time.Millisecond
+	stats := summarize([]time.Duration{10 * ms, 30 * ms, 20 * ms}, 4)
+
+	expected := Stats{
+		Sent:     4,
+		Received: 3,
+		Loss:     25,
+		Min:      10 * ms,
+		Avg:      20 * ms,
+		Max:      30 * ms,
+		Mdev:     8164965 * time.Nanosecond,
+		Jitter:   15 * ms,
+	}
+	if stats != expected {
+		t.Errorf("Got stats: %+v, expected: %+v", stats, expected)
+	}
+
+	if stats := summarize(nil, 2); stats.Loss != 100 || stats.Avg != 0 {
+		t.Errorf("Got stats: %+v, expected all lost", stats)
+	}
+}
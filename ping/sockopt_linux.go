@@ -0,0 +1,149 @@
+package ping
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// recvHopLimit asks for the hop limit of the
+// packets read from an IPv6 socket
+func recvHopLimit(c *net.IPConn) error {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVHOPLIMIT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// parseTTL returns the TTL or hop limit on the control messages, 0 if missing
+func parseTTL(oob []byte) int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+
+	for _, m := range msgs {
+		ipv4 := m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_TTL
+		ipv6 := m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_HOPLIMIT
+		if (ipv4 || ipv6) && len(m.Data) >= 4 {
+			return int(binary.NativeEndian.Uint32(m.Data))
+		}
+	}
+	return 0
+}
+
+// headerOpts sets the IP header fields of the packets written on a
+// socket shared by echoes asking for different ones, so the writes
+// go one at a time. Zero fields are set back to the socket defaults.
+type headerOpts struct {
+	lock     sync.Mutex
+	v6       bool
+	defaults [3]int // ttl, tos and path MTU discovery, read on first use
+	current  [3]int
+	read     bool
+}
+
+// sockopts returns the level and name of the ttl, tos and path MTU discovery options
+func (o *headerOpts) sockopts() [3][2]int {
+	if o.v6 {
+		return [3][2]int{
+			{syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS},
+			{syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS},
+			{syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER},
+		}
+	}
+	return [3][2]int{
+		{syscall.IPPROTO_IP, syscall.IP_TTL},
+		{syscall.IPPROTO_IP, syscall.IP_TOS},
+		{syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER},
+	}
+}
+
+// write calls write once the socket options of c match h
+func (o *headerOpts) write(c syscall.Conn, h Header, write func() error) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if h == (Header{}) && !o.read {
+		return write()
+	}
+
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	opts := o.sockopts()
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if !o.read {
+			for i, opt := range opts {
+				if o.defaults[i], serr = syscall.GetsockoptInt(int(fd), opt[0], opt[1]); serr != nil {
+					return
+				}
+			}
+			o.current, o.read = o.defaults, true
+		}
+
+		wanted := o.defaults
+		if h.TTL > 0 {
+			wanted[0] = h.TTL
+		}
+		if h.TOS > 0 {
+			wanted[1] = h.TOS
+		}
+		if h.DontFragment {
+			// DF ignoring the path MTU cached by the kernel, which
+			// fails the echoes over it until it expires, so the MTU
+			// checks see it going up again. IPV6_PMTUDISC_PROBE has
+			// the same value.
+			wanted[2] = syscall.IP_PMTUDISC_PROBE
+		}
+
+		for i, opt := range opts {
+			if wanted[i] == o.current[i] {
+				continue
+			}
+			if serr = syscall.SetsockoptInt(int(fd), opt[0], opt[1], wanted[i]); serr != nil {
+				return
+			}
+			o.current[i] = wanted[i]
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if serr != nil {
+		return os.NewSyscallError("setsockopt", serr)
+	}
+
+	return write()
+}
+
+// bindToDevice makes the socket c send from the network interface called name
+func bindToDevice(c syscall.Conn, name string) error {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.BindToDevice(int(fd), name)
+	})
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("bindtodevice", serr)
+}
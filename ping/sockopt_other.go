@@ -0,0 +1,35 @@
+//go:build !linux
+
+package ping
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// recvHopLimit is only supported on Linux,
+// elsewhere IPv6 replies have no TTL
+func recvHopLimit(c *net.IPConn) error {
+	return nil
+}
+
+func parseTTL(oob []byte) int {
+	return 0
+}
+
+// headerOpts only supports the default IP header fields out of Linux
+type headerOpts struct {
+	v6 bool
+}
+
+func (o *headerOpts) write(c syscall.Conn, h Header, write func() error) error {
+	if h != (Header{}) {
+		return errors.New("ttl, tos and don't fragment not supported")
+	}
+	return write()
+}
+
+func bindToDevice(c syscall.Conn, name string) error {
+	return errors.New("sending from an interface not supported")
+}
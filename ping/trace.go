@@ -0,0 +1,148 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxHops is the longest path traced by Traceroute when maxHops is not set
+const DefaultMaxHops = 30
+
+// Hop is a router on the path to a host, or the host itself at the end
+type Hop struct {
+	TTL  int
+	Addr net.IP        // which answered, nil if none did
+	RTT  time.Duration // round-trip time of its answer
+	Err  error         // ICMP error it answered other than TTL exceeded, eg: ErrHostUnreachable
+}
+
+// Route is the path to a host found by a traceroute
+type Route struct {
+	Addr    net.Addr // address traced
+	Hops    []Hop    // by TTL, up to the host or the last hop which answered
+	Reached bool     // the host replied
+}
+
+// Last returns the last hop which answered, false if none did
+func (r Route) Last() (Hop, bool) {
+	for i := len(r.Hops) - 1; i >= 0; i-- {
+		if r.Hops[i].Addr != nil {
+			return r.Hops[i], true
+		}
+	}
+	return Hop{}, false
+}
+
+// String renders the hops like traceroute does, one per line, eg:
+//
+//	1  192.0.2.1  1.234ms
+//	2  *
+//	3  198.51.100.1  20ms  host unreachable
+func (r Route) String() string {
+	var b strings.Builder
+	for i, h := range r.Hops {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		if h.Addr == nil {
+			fmt.Fprintf(&b, "%2d  *", h.TTL)
+			continue
+		}
+		fmt.Fprintf(&b, "%2d  %s  %s", h.TTL, h.Addr, h.RTT.Round(time.Microsecond))
+		if h.Err != nil {
+			fmt.Fprintf(&b, "  %s", h.Err)
+		}
+	}
+	return b.String()
+}
+
+// Traceroute finds the routers on the path to a given host with echoes
+// of increasing TTL, up to maxHops, see Engine.Trace.
+func Traceroute(ctx context.Context, host string, maxHops int, opts Options) (Route, error) {
+	dst, err := resolve(ctx, host, opts.Family)
+	if err != nil {
+		return Route{}, err
+	}
+
+	e, err := defaultEngine(dst, opts.Source)
+	if err != nil {
+		return Route{}, err
+	}
+
+	return e.Trace(ctx, dst, maxHops, opts)
+}
+
+// Trace sends an echo to dst for each TTL up to maxHops, DefaultMaxHops
+// if not set, all at once so it takes about the opts.Timeout of a single
+// echo. Each router answers with a TTL exceeded error, and the route ends
+// with the host reply or another ICMP error, like a host unreachable.
+// Datagram sockets don't get the errors, so the routers don't show then.
+// When ctx is done the route has the hops which answered by then, the
+// routers usually answer well before the hops past a break time out.
+// The error is only set when no echo could be sent, or ctx is done
+// before any hop answered.
+func (e *Engine) Trace(ctx context.Context, dst net.IP, maxHops int, opts Options) (Route, error) {
+	if maxHops <= 0 {
+		maxHops = DefaultMaxHops
+	}
+
+	hops := make([]Hop, maxHops)
+	errs := make([]error, maxHops)
+	var wg sync.WaitGroup
+	for i := range hops {
+		hops[i].TTL = i + 1
+		wg.Add(1)
+		go func(h *Hop, err *error) {
+			defer wg.Done()
+
+			opts := opts
+			opts.TTL = h.TTL
+			reply, echoErr := e.Echo(ctx, dst, opts)
+			var icmpErr *ICMPError
+			switch {
+			case echoErr == nil:
+				h.Addr, h.RTT = dst, reply.RTT
+			case errors.As(echoErr, &icmpErr):
+				h.Addr, h.RTT = icmpErr.From, reply.RTT
+				if !errors.Is(echoErr, ErrTTLExceeded) {
+					h.Err = icmpErr.Err
+				}
+			case echoErr != ErrNoReply && echoErr != ctx.Err():
+				*err = echoErr
+			}
+		}(&hops[i], &errs[i])
+	}
+	wg.Wait()
+
+	if errs[0] != nil {
+		return Route{}, errs[0]
+	}
+
+	// the echoes with a longer TTL than the path reach the host too
+	r := Route{Addr: &net.IPAddr{IP: dst}}
+	last := -1
+	for i, h := range hops {
+		if h.Addr == nil {
+			continue
+		}
+		last = i
+		if h.Addr.Equal(dst) && h.Err == nil {
+			r.Reached = true
+			break
+		}
+		if h.Err != nil {
+			break
+		}
+	}
+	r.Hops = hops[:last+1]
+	if last < 0 && ctx.Err() != nil {
+		return r, ctx.Err()
+	}
+
+	return r, nil
+}
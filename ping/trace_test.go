@@ -0,0 +1,77 @@
+package ping
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeRoute is a fake network where the echoes go through routers,
+// which answer the ones with their TTL exceeded unless they're "",
+// and reach the hosts only with a longer TTL than the path
+type fakeRoute struct {
+	*fakeConn
+	routers []string
+}
+
+func (c fakeRoute) WriteTo(b []byte, dst net.IP, h Header) error {
+	if h.TTL <= 0 || h.TTL > len(c.routers) {
+		return c.fakeConn.WriteTo(b, dst, h)
+	}
+
+	if router := c.routers[h.TTL-1]; router != "" {
+		id, seq := int(b[4])<<8|int(b[5]), int(b[6])<<8|int(b[7])
+		c.inject(&icmpMessage{Type: icmpv4TimeExceeded, Body: &icmpError{Original: quote(dst.String(), id, seq)}}, router)
+	}
+	return nil
+}
+
+var tracetests = []struct {
+	routers []string
+	delays  map[string]time.Duration
+	hops    int
+	reached bool
+	last    string
+}{
+	{[]string{"192.0.2.1", "", "198.51.100.1"}, map[string]time.Duration{"10.0.0.1": 0}, 4, true, "10.0.0.1"},
+	{[]string{"192.0.2.1", "198.51.100.1", ""}, nil, 2, false, "198.51.100.1"},
+	{nil, nil, 0, false, ""},
+}
+
+func TestEngineTrace(t *testing.T) {
+	defer func(timeout time.Duration) { TimeOut = timeout }(TimeOut)
+	TimeOut = 20 * time.Millisecond
+
+	for _, tt := range tracetests {
+		e := NewEngine(fakeRoute{newFakeConn(tt.delays), tt.routers})
+
+		r, err := e.Trace(context.Background(), net.ParseIP("10.0.0.1").To4(), 10, Options{})
+		last, _ := r.Last()
+		if err != nil || len(r.Hops) != tt.hops || r.Reached != tt.reached || (tt.last != "" && last.Addr.String() != tt.last) {
+			t.Errorf("Got route:\n%s\nreached: %t error: %v, expected %d hops to %s", r, r.Reached, err, tt.hops, tt.last)
+		}
+		e.Close()
+	}
+
+	// the hops which answered before the deadline
+	e := NewEngine(fakeRoute{newFakeConn(nil), []string{"192.0.2.1", ""}})
+	defer e.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if r, err := e.Trace(ctx, net.ParseIP("10.0.0.1").To4(), 10, Options{Timeout: time.Hour}); err != nil || len(r.Hops) != 1 {
+		t.Errorf("Got route:\n%s\nerror: %v, expected the first hop", r, err)
+	}
+}
+
+func TestRouteString(t *testing.T) {
+	r := Route{Hops: []Hop{
+		{TTL: 1, Addr: net.ParseIP("192.0.2.1"), RTT: 1234 * time.Microsecond},
+		{TTL: 2},
+		{TTL: 3, Addr: net.ParseIP("198.51.100.1"), RTT: 20 * time.Millisecond, Err: ErrHostUnreachable},
+	}}
+	expected := " 1  192.0.2.1  1.234ms\n 2  *\n 3  198.51.100.1  20ms  host unreachable"
+	if s := r.String(); s != expected {
+		t.Errorf("Got route:\n%s\nexpected:\n%s", s, expected)
+	}
+}
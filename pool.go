@@ -1,8 +1,28 @@
 package pingd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"sync"
 	"time"
+
+	"github.com/pinggg/pingd/ping"
+)
+
+// DefaultInterval is the time between checks of the hosts
+// when neither the pool nor their settings set one
+const DefaultInterval = time.Minute
+
+var (
+	// ErrNotRunning is returned when managing hosts of a pool
+	// that hasn't been started or has been shut down
+	ErrNotRunning = errors.New("pool is not running")
+
+	// ErrHostNotFound is returned when the host is not being monitored
+	ErrHostNotFound = errors.New("host not found")
 )
 
 // HostStatus is a wrap around a host (name or IP), the host status
@@ -13,93 +33,696 @@ type HostStatus struct {
 	Host   string
 	Down   bool
 	Reason error
+
+	// Check parameters when starting to monitor the host
+	Settings Settings
+
+	// Only set on UP, DOWN and DEGRADED events
+	Time        time.Time         // when the transition happened
+	WasDown     bool              // status before the transition
+	Degraded    bool              // up but over the MaxRTT or MaxLoss thresholds or under MinMTU, Reason tells which
+	WasDegraded bool              // status before the transition
+	Since       time.Duration     // time since the previous transition, the outage length on UP
+	Failures    int               // consecutive failed pings
+	RTT         time.Duration     // round-trip time of the last successful ping
+	Attrs       map[string]string // details of the last check
+	Children    []string          // on DOWN, hosts with this one as parent which are failing too
+	Route       *ping.Route       // on DOWN, the route to the host if Pool.Tracer is set and it could be traced within Pool.TraceTimeout
+	MTU         int               // path MTU found by the last check, 0 if unknown
+
+	// Set on the event telling the path MTU of a host which is up
+	// changed, to the one it had before. The status doesn't change.
+	WasMTU int
+
+	// Set on the event telling the host started flapping, Down is
+	// its status then. No more events are sent until it settles,
+	// then an UP or DOWN event tells its final status, with Since
+	// being for how long it flapped.
+	Flapping bool
+
+	// Set on the event sent when a silence ends if the status
+	// changed during it, Since being the length of the silence.
+	Silenced bool
+}
+
+// String renders the event with all its details, eg:
+//
+//	DOWN example.com: i/o timeout (4 failures, was up for 2h0m0s)
+//	UP example.com (rtt 12ms, was down for 5m0s)
+//	DEGRADED example.com: rtt 800ms over 500ms (rtt 800ms, was up for 1h0m0s)
+//	MTU example.com: path mtu 1400, was 1500 (rtt 12ms, now up)
+//	FLAPPING example.com (now up, was down for 30s)
+//	DOWN example.com: i/o timeout (was up before a 1h0m0s silence)
+func (h HostStatus) String() string {
+	status, now, was := "UP", "up", "up"
+	if h.Down {
+		status, now = "DOWN", "down"
+	} else if h.Degraded {
+		status, now = "DEGRADED", "degraded"
+	}
+	if h.WasDown {
+		was = "down"
+	} else if h.WasDegraded {
+		was = "degraded"
+	}
+
+	if h.WasMTU != 0 {
+		return fmt.Sprintf("MTU %s: %s (rtt %s, now %s)", h.Host, h.Reason, h.RTT, now)
+	}
+	if h.Flapping {
+		return fmt.Sprintf("FLAPPING %s (now %s, was %s for %s)", h.Host, now, was, h.Since)
+	}
+	if h.Silenced {
+		s := status + " " + h.Host
+		if (h.Down || h.Degraded) && h.Reason != nil {
+			s += ": " + h.Reason.Error()
+		}
+		return s + fmt.Sprintf(" (was %s before a %s silence)", was, h.Since)
+	}
+
+	s := status + " " + h.Host
+	if (h.Down || h.Degraded) && h.Reason != nil {
+		s += ": " + h.Reason.Error()
+	}
+
+	if h.Down && len(h.Children) > 0 {
+		s += fmt.Sprintf(" (%d failures, was %s for %s, %d children affected)", h.Failures, was, h.Since, len(h.Children))
+	} else if h.Down {
+		s += fmt.Sprintf(" (%d failures, was %s for %s)", h.Failures, was, h.Since)
+	} else {
+		s += fmt.Sprintf(" (rtt %s, was %s for %s)", h.RTT, was, h.Since)
+	}
+
+	return s
 }
 
 // Receiver is a functions which takes 2 channels of Host
 // in the first ones inserts Host(s) that should be monitored
-// in the second one Host(s) that should stop being monitored
-type Receiver func(chan<- HostStatus, chan<- HostStatus)
+// in the second one Host(s) that should stop being monitored.
+// It must return once ctx is done.
+type Receiver func(ctx context.Context, start chan<- HostStatus, stop chan<- HostStatus)
 
 // Notifier is a function which takes 1 channel of Host(s)
 // where it all hosts that went throw an UP or DOWN status change.
+// The channel is closed on shutdown once all pending events
+// have been delivered, the function must return after that.
 type Notifier func(<-chan HostStatus)
 
 // Loader is a function which takes 1 channel of Host(s)
 // where it should insert Host(s) that should be monitored
 // this function will run at boot time to load an initial
-// list of Host(s)
-type Loader func(chan<- HostStatus)
+// list of Host(s). It must return once ctx is done.
+type Loader func(ctx context.Context, load chan<- HostStatus)
 
 // Pool is the structure that wraps the list of Host(s) that are
 // being monitored, with the monitoring parameters and the functions
-// interfacing with the rest of the system.
+// interfacing with the rest of the system. The monitoring parameters
+// are the defaults for hosts without their own Settings.
 type Pool struct {
-	Ping      PingFunc
-	Interval  time.Duration
-	FailLimit int
-	Receive   Receiver
-	Notify    Notifier
-	Load      Loader
+	Ping         PingFunc           // used through PingChecker if Checker is not set
+	Checker      Checker            // checks hosts without Settings.Check, a Registry if not set
+	Checks       map[string]Checker // additional checkers selected by Settings.Check
+	Tracer       Tracer             // traces the hosts going down, no traceroute if not set
+	TraceTimeout time.Duration      // delay of the DOWN events for the traceroute, DefaultTraceTimeout if not set
+	Interval     time.Duration      // DefaultInterval if not set
+	FailLimit    int
+	RecoverLimit int           // FailLimit if not set
+	Timeout      time.Duration // no timeout if not set
+	FlapWindow   time.Duration // no flap detection if not set
+	FlapLimit    int           // no flap detection if not set
+	MaxRTT       time.Duration // never degraded by RTT if not set
+	MaxLoss      float64       // never degraded by loss if not set
+	DegradeLimit int           // FailLimit if not set
+	MinMTU       int           // never degraded by path MTU if not set
+	Workers      int           // max concurrent pings, DefaultWorkers if not set
+	HistorySize  int           // checks kept per host, DefaultHistorySize if not set
+	Receive      Receiver
+	Notify       Notifier // same as a Sink blocking when its 10 events queue is full
+	Sinks        []Sink
+	Load         Loader
+	Silences     *Silences     // no silences if not set
+	Store        *Store        // state is not kept across restarts if not set
+	Checkpoint   time.Duration // time between Store snapshots, DefaultCheckpoint if not set
 
-	list map[string]*Monitor
+	lock     sync.Mutex // protects all fields below
+	list     map[string]*Monitor
+	ctx      context.Context // nil when not running
+	cancel   context.CancelFunc
+	done     chan struct{} // closed once everything has stopped
+	notifyCh chan HostStatus
+	sched    *scheduler
+	queues   []*sinkQueue
+	registry *Registry // used when neither Checker nor Ping are set
 }
 
 // Start create the necessary internal channels and
 // calls all necessary functions to start the engine.
+// Use Shutdown to stop it.
 func (p *Pool) Start() {
-	p.list = make(map[string]*Monitor)
+	p.start(context.Background())
+}
+
+// Run starts the engine and blocks until ctx is done or Shutdown is
+// called, it returns once every monitor, Loader, Receiver and Notifier
+// has exited. The returned error is ctx.Err() if it stopped because of ctx.
+func (p *Pool) Run(ctx context.Context) error {
+	done := p.start(ctx)
+	<-done
+
+	return ctx.Err()
+}
+
+// Shutdown stops all monitors, waits for in-flight pings, delivers the
+// pending events to every Notifier and waits for all goroutines to exit.
+// If ctx is done before that, Shutdown returns ctx.Err().
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.lock.Lock()
+	cancel, done := p.cancel, p.done
+	p.lock.Unlock()
+
+	if cancel == nil {
+		return nil // never started
+	}
+	cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// start launches all the goroutines and returns a channel
+// closed once they have all exited.
+func (p *Pool) start(parent context.Context) <-chan struct{} {
+	ctx, cancel := context.WithCancel(parent)
+	done := make(chan struct{})
+
 	startHostCh := make(chan HostStatus, 10)
 	stopHostCh := make(chan HostStatus, 10)
 	notifyCh := make(chan HostStatus, 10)
 
+	sched := newScheduler(p.Workers, func(ctx context.Context, m *Monitor) {
+		m.check(ctx)
+	})
+	sched.start(ctx)
+
+	sinks := p.Sinks
+	if p.Notify != nil {
+		sinks = append([]Sink{{Name: "notify", Notify: p.Notify, QueueSize: 10}}, sinks...)
+	}
+	queues := make([]*sinkQueue, len(sinks))
+	for i, sink := range sinks {
+		queues[i] = newSinkQueue(sink)
+	}
+
+	p.lock.Lock()
+	p.list = make(map[string]*Monitor)
+	p.ctx = ctx
+	p.cancel = cancel
+	p.done = done
+	p.notifyCh = notifyCh
+	p.sched = sched
+	p.queues = queues
+	p.registry = NewRegistry()
+	p.lock.Unlock()
+
+	var feeders, notifier sync.WaitGroup
+
+	// restored before anything else can add the same hosts
+	if p.Store != nil {
+		p.restore()
+
+		feeders.Add(1)
+		go func() {
+			defer feeders.Done()
+			p.checkpoints(ctx)
+		}()
+	}
+
 	if p.Load != nil {
-		go p.Load(startHostCh)
+		feeders.Add(1)
+		go func() {
+			defer feeders.Done()
+			p.Load(ctx, startHostCh)
+		}()
 	}
 
-	if p.Notify != nil {
-		go p.Notify(notifyCh)
+	notifier.Add(len(queues) + 1)
+	for _, q := range queues {
+		go func(q *sinkQueue) {
+			defer notifier.Done()
+			q.run()
+		}(q)
 	}
+	go func() {
+		defer notifier.Done()
+		fanOut(notifyCh, queues)
+	}()
 
 	if p.Receive != nil {
-		go p.Receive(startHostCh, stopHostCh)
+		feeders.Add(1)
+		go func() {
+			defer feeders.Done()
+			p.Receive(ctx, startHostCh, stopHostCh)
+		}()
 	}
 
-	go p.run(startHostCh, stopHostCh, notifyCh)
+	go func() {
+		p.run(ctx, startHostCh, stopHostCh)
+
+		// no monitors can be added from now on
+		p.lock.Lock()
+		p.ctx = nil
+		p.lock.Unlock()
+
+		// the Notifier keeps consuming while monitors finish
+		// their last ping, then gets every pending event
+		sched.wait()
+		feeders.Wait()
+		close(notifyCh)
+		notifier.Wait()
+
+		if p.Store != nil {
+			p.checkpoint()
+		}
+
+		log.Println("SHUTDOWN complete")
+		close(done)
+	}()
+
+	return done
 }
 
 // run glues together the channels for communication with the host monitors
 // and the rest of the system.
-func (p *Pool) run(startHostCh, stopHostCh <-chan HostStatus, notifyCh chan<- HostStatus) {
+func (p *Pool) run(ctx context.Context, startHostCh, stopHostCh <-chan HostStatus) {
 	for {
 		select {
 
 		// START
 		case h := <-startHostCh:
-
-			if _, exists := p.list[h.Host]; exists {
-				log.Println("RESTART pinging " + h.Host)
-				go func(h *Monitor) {
-					h.Stop()
-					h.Start(p.Interval, p.FailLimit)
-				}(p.list[h.Host])
-			} else {
-				log.Println("NEW host " + h.Host)
-				p.list[h.Host] = NewMonitor(h, p.Ping, notifyCh)
-				go func(h *Monitor) {
-					h.Start(p.Interval, p.FailLimit)
-				}(p.list[h.Host])
+			if err := p.Add(h); err != nil {
+				log.Println("ERROR " + err.Error() + " " + h.Host)
 			}
 
 			// STOP
 		case h := <-stopHostCh:
+			if err := p.Remove(h.Host); err != nil {
+				log.Println("ERROR " + err.Error() + " " + h.Host)
+			}
+
+			// SHUTDOWN
+		case <-ctx.Done():
+			log.Println("SHUTDOWN stopping all monitors")
+			return
+		}
+	}
+}
+
+// Add starts monitoring a host using h as initial state,
+// if the host is already monitored it's restarted keeping
+// its current state, and its settings if h has none, eg:
+// when a Loader adds again the hosts restored from the Store.
+func (p *Pool) Add(h HostStatus) error {
+	p.lock.Lock()
+	err := p.add(h, nil)
+	p.lock.Unlock()
+
+	p.flush()
+	return err
+}
+
+// add starts monitoring a host, from the saved state if not nil,
+// must be called holding p.lock.
+func (p *Pool) add(h HostStatus, saved *HostState) error {
+	if p.ctx == nil {
+		return ErrNotRunning
+	}
+
+	m, exists := p.list[h.Host]
+	if exists && h.Settings.zero() {
+		h.Settings = m.kept()
+	}
+
+	s, checker, err := p.settings(h.Host, h.Settings)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		log.Println("RESTART pinging " + h.Host)
+	} else {
+		if saved != nil {
+			log.Println("RESTORE host " + h.Host + " " + saved.State.String())
+		} else {
+			log.Println("NEW host " + h.Host)
+		}
+		m = NewMonitor(h, checker, p.notifyCh)
+		m.deps = p
+		m.tracer, m.traceTimeout = p.Tracer, p.TraceTimeout
+		if m.traceTimeout <= 0 {
+			m.traceTimeout = DefaultTraceTimeout
+		}
+		m.history = newHistory(p.HistorySize, h.Down)
+		if saved != nil {
+			m.restore(*saved)
+		}
+		p.list[h.Host] = m
+	}
+
+	m.keep(h.Settings)
+	p.restart(m, s, checker)
+	if saved == nil {
+		p.save(m.change())
+	}
+	return nil
+}
+
+// Update restarts monitoring an already monitored host
+// resetting its state and settings to the ones given by h.
+func (p *Pool) Update(h HostStatus) error {
+	defer p.flush()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.ctx == nil {
+		return ErrNotRunning
+	}
+
+	m, exists := p.list[h.Host]
+	if !exists {
+		return ErrHostNotFound
+	}
+
+	s, checker, err := p.settings(h.Host, h.Settings)
+	if err != nil {
+		return err
+	}
+
+	log.Println("UPDATE host " + h.Host)
+	m.reset(h)
+	m.keep(h.Settings)
+	p.restart(m, s, checker)
+	p.save(m.change())
+	return nil
+}
+
+// Remove stops monitoring a host and forgets about it.
+func (p *Pool) Remove(host string) error {
+	defer p.flush()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	m, exists := p.list[host]
+	if !exists {
+		return ErrHostNotFound
+	}
+
+	log.Println("STOP pinging " + host)
+	p.sched.remove(m)
+	delete(p.list, host)
+	p.save(HostState{Host: host, Removed: true})
+	return nil
+}
 
-			if _, exists := p.list[h.Host]; exists {
-				log.Println("STOP pinging " + h.Host)
-				p.list[h.Host].Stop()
+// Hosts returns the sorted list of monitored hosts.
+func (p *Pool) Hosts() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 
-			} else {
-				log.Println("ERROR host not found " + h.Host)
+	hosts := make([]string, 0, len(p.list))
+	for host := range p.list {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	return hosts
+}
+
+// Status returns the current status of a monitored host.
+func (p *Pool) Status(host string) (MonitorStatus, error) {
+	p.lock.Lock()
+	m, exists := p.list[host]
+	p.lock.Unlock()
+
+	if !exists {
+		return MonitorStatus{}, ErrHostNotFound
+	}
+
+	return m.Status(), nil
+}
+
+// History returns the recent checks and transitions of a monitored host.
+func (p *Pool) History(host string) (History, error) {
+	p.lock.Lock()
+	m, exists := p.list[host]
+	p.lock.Unlock()
+
+	if !exists {
+		return History{}, ErrHostNotFound
+	}
+
+	return m.History(), nil
+}
+
+// Availability returns the uptime, MTTR and MTBF of a monitored host.
+func (p *Pool) Availability(host string) (Availability, error) {
+	h, err := p.History(host)
+	if err != nil {
+		return Availability{}, err
+	}
+
+	return h.Availability(), nil
+}
+
+// Backlog returns the number of events waiting
+// to be copied to the queues of the sinks
+func (p *Pool) Backlog() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.notifyCh)
+}
+
+// SinkStats returns the delivery counters of every Sink,
+// starting with Notify if set.
+func (p *Pool) SinkStats() []SinkStats {
+	p.lock.Lock()
+	queues := p.queues
+	p.lock.Unlock()
+
+	stats := make([]SinkStats, len(queues))
+	for i, q := range queues {
+		stats[i] = q.stats()
+	}
+
+	return stats
+}
+
+// SchedulerStats returns the load of the pool
+func (p *Pool) SchedulerStats() SchedulerStats {
+	p.lock.Lock()
+	sched := p.sched
+	p.lock.Unlock()
+
+	if sched == nil {
+		return SchedulerStats{}
+	}
+
+	return sched.stats()
+}
+
+// parentsFailing tells whether any of the parents being monitored
+// is failing, suspect or down, see dependencies. A suspect parent
+// counts as its children usually fail on the same check round.
+func (p *Pool) parentsFailing(parents []string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, parent := range parents {
+		if m, exists := p.list[parent]; exists && m.Status().State.failing() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// children returns the sorted hosts with host as parent which are
+// failing, see dependencies. The suspect ones count as they're held
+// back as unreachable once they reach their FailLimit.
+func (p *Pool) children(host string) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var children []string
+	for child, m := range p.list {
+		status := m.Status()
+		if !status.State.failing() {
+			continue
+		}
+		for _, parent := range status.Settings.Parents {
+			if parent == host {
+				children = append(children, child)
+				break
 			}
 		}
 	}
+	sort.Strings(children)
+
+	return children
+}
+
+// silenced tells whether any of the pool silences
+// covers the host now, see dependencies
+func (p *Pool) silenced(host string, tags []string) bool {
+	return p.Silences != nil && p.Silences.active(host, tags, time.Now())
+}
+
+// changed stores the new state of a monitored host, see dependencies
+func (p *Pool) changed(m *Monitor) {
+	defer p.flush()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// it may have been removed while checking
+	if p.list[m.host] == m {
+		p.save(m.change())
+	}
+}
+
+// save queues the state of a host to append it to the Store
+// if set, must be called holding p.lock, see flush.
+func (p *Pool) save(state HostState) {
+	if p.Store != nil {
+		p.Store.queue(state)
+	}
+}
+
+// flush writes the host states queued by save, it's called after
+// releasing p.lock so the pool is not held back by the disk.
+func (p *Pool) flush() {
+	if p.Store == nil {
+		return
+	}
+	if err := p.Store.flush(); err != nil {
+		log.Println("ERROR storing: " + err.Error())
+	}
+}
+
+// restore monitors again the hosts of the Store
+func (p *Pool) restore() {
+	states, err := p.Store.load()
+	if err != nil {
+		log.Println("ERROR restoring hosts: " + err.Error())
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i := range states {
+		h := HostStatus{Host: states[i].Host, Down: states[i].State.Down(), Settings: states[i].Settings}
+		if err := p.add(h, &states[i]); err != nil {
+			log.Println("ERROR " + err.Error() + " " + h.Host)
+		}
+	}
+}
+
+// checkpoints snapshots the hosts on the Store every Checkpoint until ctx is done
+func (p *Pool) checkpoints(ctx context.Context) {
+	interval := p.Checkpoint
+	if interval <= 0 {
+		interval = DefaultCheckpoint
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.checkpoint()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkpoint replaces the Store snapshot with the state of all hosts
+func (p *Pool) checkpoint() {
+	p.lock.Lock()
+	states := make([]HostState, 0, len(p.list))
+	for _, m := range p.list {
+		states = append(states, m.save())
+	}
+	replace := p.Store.checkpoint()
+	p.lock.Unlock()
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Host < states[j].Host })
+	if err := replace(states); err != nil {
+		log.Println("ERROR checkpointing: " + err.Error())
+	}
+}
+
+// restart applies the settings to the monitor and (re)schedules
+// its checks, must be called holding p.lock.
+func (p *Pool) restart(m *Monitor, s Settings, checker Checker) {
+	m.configure(s, checker)
+	p.sched.add(m, s.Interval)
+}
+
+// settings fills the missing host settings with the pool
+// defaults and returns them with the checker to use for host.
+func (p *Pool) settings(host string, s Settings) (Settings, Checker, error) {
+	defaults := Settings{
+		Interval:     p.Interval,
+		FailLimit:    p.FailLimit,
+		RecoverLimit: p.RecoverLimit,
+		Timeout:      p.Timeout,
+		FlapWindow:   p.FlapWindow,
+		FlapLimit:    p.FlapLimit,
+		MaxRTT:       p.MaxRTT,
+		MaxLoss:      p.MaxLoss,
+		DegradeLimit: p.DegradeLimit,
+		MinMTU:       p.MinMTU,
+	}
+	if defaults.RecoverLimit == 0 {
+		defaults.RecoverLimit = defaults.FailLimit
+	}
+	if defaults.DegradeLimit == 0 {
+		defaults.DegradeLimit = defaults.FailLimit
+	}
+	s = s.merge(defaults)
+	if s.Interval <= 0 {
+		// the scheduler would run the checks back to back
+		s.Interval = DefaultInterval
+	}
+
+	if s.Check != "" {
+		checker, ok := p.Checks[s.Check]
+		if !ok {
+			return s, nil, fmt.Errorf("%w %q", ErrUnknownCheck, s.Check)
+		}
+		return s, checker, nil
+	}
+
+	checker := p.Checker
+	if checker == nil {
+		if p.Ping != nil {
+			return s, PingChecker(p.Ping), nil
+		}
+		checker = p.registry
+	}
+
+	// resolve the scheme once instead of on every check
+	if r, ok := checker.(*Registry); ok {
+		var err error
+		if checker, err = r.Lookup(host); err != nil {
+			return s, nil, err
+		}
+	}
+
+	return s, checker, nil
 }
@@ -1,10 +1,17 @@
 package pingd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/pinggg/pingd/ping"
 )
 
 // TestUpDown tests correct event stream is coming from the monitoring pool
@@ -20,26 +27,38 @@ func TestUpDownStartStop(t *testing.T) {
 	load := []string{"h1", "h2"}
 
 	resultSeq := []HostStatus{
-		HostStatus{"h2", true},  // h2 goes down first
-		HostStatus{"h1", true},  // h1 follows
-		HostStatus{"h2", false}, // h2 goes up
-		HostStatus{"h1", false}, // h1 goes up
-		HostStatus{"h1", true},  // h2 goes down
-		HostStatus{"h2", true},  // h1 goes down
+		HostStatus{Host: "h2", Down: true},  // h2 goes down first
+		HostStatus{Host: "h1", Down: true},  // h1 follows
+		HostStatus{Host: "h2", Down: false}, // h2 goes up
+		HostStatus{Host: "h1", Down: false}, // h1 goes up
+		HostStatus{Host: "h1", Down: true},  // h2 goes down
+		HostStatus{Host: "h2", Down: true},  // h1 goes down
 	}
 
-	startHostChFW, stopHostChFW, notifyChFW := createTestPool(seq, load)
+	pool, startHostChFW, stopHostChFW, notifyChFW := createTestPool(seq, load)
+	defer pool.Shutdown(context.Background())
 
-	// Test expected events for h1 and h2
+	// Test expected events for h1 and h2, hosts are spread over
+	// the interval so only the order of each host is known
+	expectedSeq := make(map[string][]HostStatus)
 	for _, expected := range resultSeq {
+		expectedSeq[expected.Host] = append(expectedSeq[expected.Host], expected)
+	}
+	for range resultSeq {
 		event := <-notifyChFW
-		if event.Host != expected.Host || event.Down != expected.Down {
+		if len(expectedSeq[event.Host]) == 0 {
+			t.Errorf("Got unexpected event: %s %t \n", event.Host, event.Down)
+			continue
+		}
+		expected := expectedSeq[event.Host][0]
+		expectedSeq[event.Host] = expectedSeq[event.Host][1:]
+		if event.Down != expected.Down {
 			t.Errorf("Got event: %s %t, expected: %s %t \n", event.Host, event.Down, expected.Host, expected.Down)
 		}
 	}
 
-	startHostChFW <- HostStatus{"h3", false} // start h3 as UP
-	startHostChFW <- HostStatus{"h4", true}  // start h4 as DOWN
+	startHostChFW <- HostStatus{Host: "h3", Down: false} // start h3 as UP
+	startHostChFW <- HostStatus{Host: "h4", Down: true}  // start h4 as DOWN
 
 	// Expect h4 to come UP (down=false) first
 	event := <-notifyChFW
@@ -63,13 +82,667 @@ func TestUpDownStartStop(t *testing.T) {
 	time.Sleep(time.Millisecond * 20)
 }
 
-func createTestPool(pingseq map[string][]bool, loadseq []string) (start, stop, notify chan HostStatus) {
+// TestShutdown tests that the pool waits for in-flight pings
+// and delivers their events before Shutdown returns
+func TestShutdown(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	pinging := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	var inFlight int32
+
+	var events []HostStatus
+	notified := make(chan struct{})
+
+	var pool = &Pool{
+		Interval:  time.Millisecond,
+		FailLimit: 1,
+		Load:      NewLoaderFunc([]string{"h1"}),
+		Notify: func(notify <-chan HostStatus) {
+			defer close(notified)
+			for h := range notify {
+				events = append(events, h)
+			}
+		},
+		Ping: func(host string) (bool, error) {
+			atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			once.Do(func() { close(pinging) })
+			<-release
+			return false, nil
+		},
+	}
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- pool.Run(context.Background())
+	}()
+
+	<-pinging
+
+	// the ping is blocked so shutdown can't complete in time
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Got shutdown error: %v, expected: %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Errorf("Got shutdown error: %v, expected: nil", err)
+	}
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Got run error: %v, expected: nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Shutdown")
+	}
+
+	select {
+	case <-notified:
+	default:
+		t.Error("Notifier still running after Shutdown")
+	}
+
+	if n := atomic.LoadInt32(&inFlight); n != 0 {
+		t.Errorf("Got %d pings in flight after Shutdown, expected: 0", n)
+	}
+
+	if len(events) != 1 || events[0].Host != "h1" || !events[0].Down {
+		t.Errorf("Got events: %v, expected: h1 DOWN", events)
+	}
+}
+
+// TestPoolAPI tests managing and inspecting hosts through the Pool methods
+func TestPoolAPI(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	seq := make(map[string][]bool)
+	seq["h1"] = []bool{false, false}
+	seq["h2"] = []bool{true, true, true, true, true, true, true, true}
+
+	notifyCh := make(chan HostStatus)
+	var pool = &Pool{
+		Interval:  time.Millisecond,
+		FailLimit: 2,
+		Notify:    NewTestNotifyFunc(notifyCh),
+		Ping:      NewTestPingFunc(seq),
+	}
+
+	if err := pool.Add(HostStatus{Host: "h1"}); err != ErrNotRunning {
+		t.Errorf("Got add error: %v, expected: %v", err, ErrNotRunning)
+	}
+
+	pool.Start()
+
+	pool.Add(HostStatus{Host: "h1"})
+	pool.Add(HostStatus{Host: "h2", Down: true})
+
+	if hosts := pool.Hosts(); len(hosts) != 2 || hosts[0] != "h1" || hosts[1] != "h2" {
+		t.Errorf("Got hosts: %v, expected: [h1 h2]", hosts)
+	}
+
+	for i := 0; i < 2; i++ {
+		event := <-notifyCh
+		status, err := pool.Status(event.Host)
+		if err != nil {
+			t.Errorf("Got status error: %v for host %s", err, event.Host)
+		}
+		if status.Down != event.Down || status.LastCheck.IsZero() {
+			t.Errorf("Got status: %+v, expected: down %t and checked", status, event.Down)
+		}
+		if event.Host == "h1" && (status.Failures != 2 || status.LastError == nil) {
+			t.Errorf("Got status: %+v, expected: 2 failures and an error", status)
+		}
+	}
+
+	if err := pool.Remove("h1"); err != nil {
+		t.Errorf("Got remove error: %v, expected: nil", err)
+	}
+	if _, err := pool.Status("h1"); err != ErrHostNotFound {
+		t.Errorf("Got status error: %v, expected: %v", err, ErrHostNotFound)
+	}
+	if err := pool.Update(HostStatus{Host: "h1"}); err != ErrHostNotFound {
+		t.Errorf("Got update error: %v, expected: %v", err, ErrHostNotFound)
+	}
+
+	// h2 is up, updating it as down makes it come back up
+	if err := pool.Update(HostStatus{Host: "h2", Down: true}); err != nil {
+		t.Errorf("Got update error: %v, expected: nil", err)
+	}
+	if event := <-notifyCh; event.Host != "h2" || event.Down {
+		t.Errorf("Got event: %s %t, expected: %s %t", event.Host, event.Down, "h2", false)
+	}
+
+	go func() {
+		for range notifyCh {
+		}
+	}()
+	pool.Shutdown(context.Background())
+	close(notifyCh)
+
+	if err := pool.Add(HostStatus{Host: "h3"}); err != ErrNotRunning {
+		t.Errorf("Got add error: %v, expected: %v", err, ErrNotRunning)
+	}
+	if hosts := pool.Hosts(); len(hosts) != 1 || hosts[0] != "h2" {
+		t.Errorf("Got hosts: %v, expected: [h2]", hosts)
+	}
+}
+
+// TestEventDetails tests the transition details carried by UP and DOWN events
+func TestEventDetails(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	seq := make(map[string][]bool)
+	seq["h1"] = []bool{true, false, false, true, true, true, true}
+
+	pool, _, _, notifyChFW := createTestPool(seq, []string{"h1"})
+	defer pool.Shutdown(context.Background())
+
+	down := <-notifyChFW
+	if !down.Down || down.WasDown || down.Failures != 2 || down.Reason != errTestPing {
+		t.Errorf("Got DOWN event: %+v, expected 2 failures from up", down)
+	}
+	if down.Time.IsZero() || down.Since <= 0 {
+		t.Errorf("Got DOWN event: %+v, expected transition time", down)
+	}
+
+	up := <-notifyChFW
+	if up.Down || !up.WasDown || up.Failures != 0 {
+		t.Errorf("Got UP event: %+v, expected no failures from down", up)
+	}
+	if up.Since != up.Time.Sub(down.Time) {
+		t.Errorf("Got outage of %s, expected: %s", up.Since, up.Time.Sub(down.Time))
+	}
+
+	go func() {
+		for range notifyChFW {
+		}
+	}()
+}
+
+// TestHostSettings tests that host settings override the pool defaults
+func TestHostSettings(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	seq := make(map[string][]bool)
+	seq["h1"] = []bool{false, true, true, false, true, true, true}
+	for i := 0; i < 100; i++ {
+		seq["h1"] = append(seq["h1"], true)
+	}
+
+	notifyCh := make(chan HostStatus)
+	block := make(chan struct{})
+	defer close(block)
+
+	var pool = &Pool{
+		Interval:  time.Hour,
+		FailLimit: 5,
+		Notify:    NewTestNotifyFunc(notifyCh),
+		Checks: map[string]Checker{
+			"seq": PingChecker(NewTestPingFunc(seq)),
+			"block": PingChecker(func(host string) (bool, error) {
+				<-block
+				return true, nil
+			}),
+		},
+	}
+	pool.Start()
+
+	err := pool.Add(HostStatus{Host: "h0", Settings: Settings{Check: "nope"}})
+	if !errors.Is(err, ErrUnknownCheck) {
+		t.Errorf("Got add error: %v, expected: %v", err, ErrUnknownCheck)
+	}
+
+	var settings Settings
+	for _, kv := range [][2]string{{"interval", "1ms"}, {"failLimit", "1"}, {"recoverLimit", "3"}, {"check", "seq"}} {
+		if err := settings.Set(kv[0], kv[1]); err != nil {
+			t.Errorf("Got set error: %v for %s", err, kv[0])
+		}
+	}
+	pool.Add(HostStatus{Host: "h1", Settings: settings})
+
+	// down at the first failure, up after 3 successes in a row
+	if event := <-notifyCh; event.Host != "h1" || !event.Down {
+		t.Errorf("Got event: %s %t, expected: %s %t", event.Host, event.Down, "h1", true)
+	}
+	if event := <-notifyCh; event.Host != "h1" || event.Down {
+		t.Errorf("Got event: %s %t, expected: %s %t", event.Host, event.Down, "h1", false)
+	}
+
+	status, _ := pool.Status("h1")
+	if status.Settings.Interval != time.Millisecond || status.Settings.Timeout != 0 {
+		t.Errorf("Got settings: %+v, expected host interval and pool timeout", status.Settings)
+	}
+	pool.Remove("h1")
+
+	pool.Add(HostStatus{Host: "h2", Settings: Settings{Interval: time.Millisecond, FailLimit: 1, Timeout: time.Millisecond, Check: "block"}})
+	if event := <-notifyCh; event.Host != "h2" || event.Reason != ErrTimeout {
+		t.Errorf("Got event: %s %v, expected: %s %v", event.Host, event.Reason, "h2", ErrTimeout)
+	}
+
+	// checks back to back without an interval
+	pool.Add(HostStatus{Host: "h3", Settings: Settings{Interval: -time.Second, Check: "seq"}})
+	if status, _ := pool.Status("h3"); status.Settings.Interval != DefaultInterval {
+		t.Errorf("Got interval: %s, expected: %s", status.Settings.Interval, DefaultInterval)
+	}
+
+	go func() {
+		for range notifyCh {
+		}
+	}()
+	pool.Shutdown(context.Background())
+	close(notifyCh)
+}
+
+// TestChecker tests that check results reach the status and
+// that removing a host cancels its running check
+func TestChecker(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	checked := make(chan struct{}, 1)
+	started := make(chan struct{})
+	cancelled := make(chan error)
+
+	var pool = &Pool{
+		Interval:  time.Millisecond,
+		FailLimit: 1,
+		Checker: CheckerFunc(func(ctx context.Context, host string) Result {
+			if host == "h1" {
+				select {
+				case checked <- struct{}{}:
+				default:
+				}
+				return Result{Up: true, RTT: 5 * time.Millisecond, Attrs: map[string]string{AttrTTL: "64"}}
+			}
+
+			close(started)
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+			return Result{Err: ctx.Err()}
+		}),
+	}
+	pool.Start()
+	defer pool.Shutdown(context.Background())
+
+	pool.Add(HostStatus{Host: "h1"})
+	<-checked
+	<-checked
+
+	status, _ := pool.Status("h1")
+	if status.Down || status.RTT != 5*time.Millisecond || status.Attrs[AttrTTL] != "64" {
+		t.Errorf("Got status: %+v, expected up with the check RTT and attributes", status)
+	}
+
+	pool.Add(HostStatus{Host: "h2"})
+	<-started
+	pool.Remove("h2")
+
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Errorf("Got check error: %v, expected: %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Error("Check still running after Remove")
+	}
+}
+
+// TestProbeOptions tests the ICMP echo settings
+// of a host reach its checks through the context
+func TestProbeOptions(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	optsCh := make(chan ping.Options, 1)
+	var pool = &Pool{
+		Interval:  time.Millisecond,
+		FailLimit: 1,
+		Checker: CheckerFunc(func(ctx context.Context, host string) Result {
+			select {
+			case optsCh <- probeOptions(ctx, ping.Options{Count: 3, TTL: 32}):
+			default:
+			}
+			return Result{Up: true}
+		}),
+	}
+	pool.Start()
+	defer pool.Shutdown(context.Background())
+
+	var settings Settings
+	for _, kv := range [][2]string{{"payloadSize", "1400"}, {"dscp", "46"}, {"dontFragment", "true"}, {"source", "eth1"}} {
+		if err := settings.Set(kv[0], kv[1]); err != nil {
+			t.Errorf("Got set error: %v for %s", err, kv[0])
+		}
+	}
+	pool.Add(HostStatus{Host: "h1", Settings: settings})
+
+	expected := ping.Options{Count: 3, Size: 1400, TTL: 32, TOS: 184, DontFragment: true, Source: "eth1"}
+	if opts := <-optsCh; opts != expected {
+		t.Errorf("Got options: %+v, expected: %+v", opts, expected)
+	}
+}
+
+// TestPathMTU tests the path MTU changes of an up host are notified
+// and that it's degraded under MinMTU
+func TestPathMTU(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	var lock sync.Mutex
+	mtus := []int{1500, 1500, 1400, 1300, 1500}
+	notifyCh := make(chan HostStatus, 10)
+	var pool = &Pool{
+		Interval:     time.Millisecond,
+		FailLimit:    1,
+		DegradeLimit: 1,
+		MinMTU:       1350,
+		Notify:       NewTestNotifyFunc(notifyCh),
+		Checker: CheckerFunc(func(ctx context.Context, host string) Result {
+			lock.Lock()
+			defer lock.Unlock()
+			mtu := mtus[0]
+			if len(mtus) > 1 {
+				mtus = mtus[1:]
+			}
+			return Result{Up: true, MTU: mtu}
+		}),
+	}
+	pool.Start()
+	defer pool.Shutdown(context.Background())
+	pool.Add(HostStatus{Host: "h1"})
+
+	if h := <-notifyCh; h.WasMTU != 1500 || h.MTU != 1400 || h.Degraded {
+		t.Errorf("Got event: %s, expected the path mtu change", h)
+	}
+	if h := <-notifyCh; !h.Degraded || h.MTU != 1300 || h.Reason.Error() != "path mtu 1300 under 1350" {
+		t.Errorf("Got event: %s, expected degraded under the min mtu", h)
+	}
+	if h := <-notifyCh; h.Degraded || h.WasMTU != 0 || h.MTU != 1500 {
+		t.Errorf("Got event: %s, expected up with the mtu back", h)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case h := <-notifyCh:
+		t.Errorf("Got event: %s, expected none while the mtu stays", h)
+	default:
+	}
+	if status, _ := pool.Status("h1"); status.MTU != 1500 {
+		t.Errorf("Got mtu: %d, expected: %d", status.MTU, 1500)
+	}
+}
+
+// TestPingChecker tests the adapter of the old PingFunc
+func TestPingChecker(t *testing.T) {
+	checker := PingChecker(func(host string) (bool, error) {
+		time.Sleep(time.Millisecond)
+		return host == "up", errTestPing
+	})
+
+	if r := checker.Check(context.Background(), "up"); !r.Up || r.Err != nil || r.RTT < time.Millisecond {
+		t.Errorf("Got result: %+v, expected up with RTT", r)
+	}
+	if r := checker.Check(context.Background(), "down"); r.Up || r.Err != errTestPing || r.RTT != 0 {
+		t.Errorf("Got result: %+v, expected down with %v", r, errTestPing)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if r := checker.Check(ctx, "up"); r.Up || r.Err != context.Canceled {
+		t.Errorf("Got result: %+v, expected: %v", r, context.Canceled)
+	}
+}
+
+// TestParents tests that a parent DOWN event lists its children
+// and that their own events wait until the parent is up
+func TestParents(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	var pool *Pool
+	var gwUp int32
+	notifyCh := make(chan HostStatus)
+
+	pool = &Pool{
+		Interval:  5 * time.Millisecond,
+		FailLimit: 3,
+		Notify:    NewTestNotifyFunc(notifyCh),
+		Checker: CheckerFunc(func(ctx context.Context, host string) Result {
+			if host == "gw" {
+				return Result{Up: atomic.LoadInt32(&gwUp) == 1, Err: errTestPing}
+			}
+			// start failing only once the parent is failing
+			for atomic.LoadInt32(&gwUp) == 0 {
+				if status, _ := pool.Status("gw"); status.State != StateUp {
+					return Result{Err: errTestPing}
+				}
+				select {
+				case <-ctx.Done():
+					return Result{Err: ctx.Err()}
+				case <-time.After(time.Millisecond):
+				}
+			}
+			return Result{Err: errTestPing}
+		}),
+	}
+	pool.Start()
+
+	pool.Add(HostStatus{Host: "gw"})
+	pool.Add(HostStatus{Host: "h1", Settings: Settings{FailLimit: 1, Parents: []string{"gw"}}})
+
+	down := <-notifyCh
+	if down.Host != "gw" || !down.Down || len(down.Children) != 1 || down.Children[0] != "h1" {
+		t.Errorf("Got event: %+v, expected gw DOWN with child h1", down)
+	}
+	if status, _ := pool.Status("h1"); status.State != StateUnreachable {
+		t.Errorf("Got h1 state: %s, expected: %s", status.State, StateUnreachable)
+	}
+
+	// h1 may be checked before the UP event of gw is sent
+	atomic.StoreInt32(&gwUp, 1)
+	expected := map[string]bool{"gw": false, "h1": true}
+	for range expected {
+		if event := <-notifyCh; event.Down != expected[event.Host] {
+			t.Errorf("Got event: %s, expected gw UP and h1 DOWN", event)
+		}
+	}
+
+	go func() {
+		for range notifyCh {
+		}
+	}()
+	pool.Shutdown(context.Background())
+	close(notifyCh)
+}
+
+// TestParentChildren tests the DOWN event of a parent lists all its
+// failing children, not only the ones down on their own by then
+func TestParentChildren(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	const n = 8
+	var lock sync.Mutex
+	var gwChecks int32
+	checked := make(map[string]bool)
+	allChecked := make(chan struct{})
+
+	notifyCh := make(chan HostStatus, 10)
+	var pool = &Pool{
+		Interval:  5 * time.Millisecond,
+		FailLimit: 3,
+		Notify:    NewTestNotifyFunc(notifyCh),
+		Checker: CheckerFunc(func(ctx context.Context, host string) Result {
+			if host != "gw" {
+				lock.Lock()
+				if !checked[host] {
+					if checked[host] = true; len(checked) == n {
+						close(allChecked)
+					}
+				}
+				lock.Unlock()
+				return Result{Err: errTestPing}
+			}
+			// the parent goes down once every child failed, not
+			// necessarily FailLimit times like it did
+			if atomic.AddInt32(&gwChecks, 1) == 3 {
+				select {
+				case <-allChecked:
+				case <-ctx.Done():
+				}
+			}
+			return Result{Err: errTestPing}
+		}),
+	}
+	pool.Start()
+	defer pool.Shutdown(context.Background())
+
+	// the children are added once the parent is failing,
+	// so they're held back instead of going down first
+	pool.Add(HostStatus{Host: "gw"})
+	for status, _ := pool.Status("gw"); status.State != StateSuspect; status, _ = pool.Status("gw") {
+		time.Sleep(time.Millisecond)
+	}
+	var children []string
+	for i := 1; i <= n; i++ {
+		child := fmt.Sprintf("h%d", i)
+		children = append(children, child)
+		pool.Add(HostStatus{Host: child, Settings: Settings{Parents: []string{"gw"}}})
+	}
+
+	down := <-notifyCh
+	if down.Host != "gw" || !down.Down || strings.Join(down.Children, ",") != strings.Join(children, ",") {
+		t.Errorf("Got event: %s children: %v, expected gw DOWN with children: %v", down, down.Children, children)
+	}
+}
+
+// TestDegradedParent tests a slow parent doesn't hold
+// back the DOWN events of its children
+func TestDegradedParent(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	notifyCh := make(chan HostStatus, 10)
+	var pool = &Pool{
+		Interval:     time.Millisecond,
+		FailLimit:    2,
+		MaxRTT:       10 * time.Millisecond,
+		DegradeLimit: 1,
+		Notify:       NewTestNotifyFunc(notifyCh),
+		Checker: CheckerFunc(func(ctx context.Context, host string) Result {
+			if host == "gw" {
+				return Result{Up: true, RTT: time.Second}
+			}
+			return Result{Err: errTestPing}
+		}),
+	}
+	pool.Start()
+	defer pool.Shutdown(context.Background())
+
+	pool.Add(HostStatus{Host: "gw"})
+	if event := <-notifyCh; event.Host != "gw" || !event.Degraded {
+		t.Fatalf("Got event: %s, expected gw DEGRADED", event)
+	}
+	pool.Add(HostStatus{Host: "h1", Settings: Settings{Parents: []string{"gw"}}})
+
+	select {
+	case event := <-notifyCh:
+		if event.Host != "h1" || !event.Down {
+			t.Errorf("Got event: %s, expected h1 DOWN", event)
+		}
+	case <-time.After(time.Second):
+		status, _ := pool.Status("h1")
+		t.Errorf("Got h1 state: %s and no event, expected DOWN", status.State)
+	}
+}
+
+var settingtests = []struct {
+	name, value string
+	err         string
+}{
+	{"interval", "10s", ""},
+	{"interval", "10", "invalid interval: time: missing unit in duration \"10\""},
+	{"timeout", "-1s", "invalid timeout: must not be negative"},
+	{"failLimit", "0", "invalid failLimit: must be at least 1"},
+	{"recoverLimit", "x", "invalid recoverLimit: strconv.Atoi: parsing \"x\": invalid syntax"},
+	{"maxLoss", "120%", "invalid maxLoss: must be between 0 and 100"},
+	{"minMTU", "1280", ""},
+	{"ttl", "0", "invalid ttl: must be between 1 and 255"},
+	{"tos", "0xb8", ""},
+	{"dscp", "64", "invalid dscp: must be between 0 and 63"},
+	{"dontFragment", "yes", "invalid dontFragment: strconv.ParseBool: parsing \"yes\": invalid syntax"},
+	{"color", "red", "unknown setting \"color\""},
+}
+
+func TestSettingsSet(t *testing.T) {
+	for _, tt := range settingtests {
+		var s Settings
+		err := s.Set(tt.name, tt.value)
+		if (err == nil && tt.err != "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("Got error: %v setting %s=%s, expected: %q", err, tt.name, tt.value, tt.err)
+		}
+	}
+}
+
+var stringtests = []struct {
+	status HostStatus
+	str    string
+}{
+	{
+		HostStatus{Host: "h1", Down: true, Reason: errTestPing, Failures: 4, Since: 2 * time.Hour},
+		"DOWN h1: test ping failed (4 failures, was up for 2h0m0s)",
+	},
+	{
+		HostStatus{Host: "h1", WasDown: true, RTT: 12 * time.Millisecond, Since: 5 * time.Minute},
+		"UP h1 (rtt 12ms, was down for 5m0s)",
+	},
+	{
+		HostStatus{Host: "h1", Degraded: true, Reason: errors.New("rtt 800ms over 500ms"), RTT: 800 * time.Millisecond, Since: time.Hour},
+		"DEGRADED h1: rtt 800ms over 500ms (rtt 800ms, was up for 1h0m0s)",
+	},
+	{
+		HostStatus{Host: "h1", Reason: errors.New("path mtu 1400, was 1500"), RTT: 12 * time.Millisecond, MTU: 1400, WasMTU: 1500},
+		"MTU h1: path mtu 1400, was 1500 (rtt 12ms, now up)",
+	},
+	{
+		HostStatus{Host: "h1", WasDegraded: true, RTT: 12 * time.Millisecond, Since: time.Minute},
+		"UP h1 (rtt 12ms, was degraded for 1m0s)",
+	},
+	{
+		HostStatus{Host: "h1", Down: true, Reason: errTestPing, Since: 30 * time.Second, Flapping: true},
+		"FLAPPING h1 (now down, was up for 30s)",
+	},
+	{
+		HostStatus{Host: "h1", Down: true, Reason: errTestPing, Since: time.Hour, Silenced: true},
+		"DOWN h1: test ping failed (was up before a 1h0m0s silence)",
+	},
+}
+
+func TestHostStatusString(t *testing.T) {
+	for _, tt := range stringtests {
+		if str := tt.status.String(); str != tt.str {
+			t.Errorf("Got: %q, expected: %q", str, tt.str)
+		}
+	}
+}
+
+func createTestPool(pingseq map[string][]bool, loadseq []string) (pool *Pool, start, stop, notify chan HostStatus) {
 	startHostChFW := make(chan HostStatus)
 	stopHostChFW := make(chan HostStatus)
 	notifyChFW := make(chan HostStatus)
 
-	var pool = &Pool{
-		Interval:  time.Millisecond,
+	pool = &Pool{
+		Interval:  10 * time.Millisecond,
 		FailLimit: 2,
 		Receive:   NewTestReceiverFunc(startHostChFW, stopHostChFW),
 		Notify:    NewTestNotifyFunc(notifyChFW),
@@ -77,15 +750,15 @@ func createTestPool(pingseq map[string][]bool, loadseq []string) (start, stop, n
 		Ping:      NewTestPingFunc(pingseq),
 	}
 
-	go pool.Start()
+	pool.Start()
 
-	return startHostChFW, stopHostChFW, notifyChFW
+	return pool, startHostChFW, stopHostChFW, notifyChFW
 }
 
 // NewTestPingFunc gets a map with a host and a sequence of ping results
 // and creates a ping function stub that will yield one by one the result
 // sequence for each host, returning 'false' for by default when the sequence
-// is over
+// is over. Failed pings return errTestPing.
 func NewTestPingFunc(sequence map[string][]bool) func(string) (bool, error) {
 	i := make(map[string]int, len(sequence))
 	for host := range sequence {
@@ -93,30 +766,38 @@ func NewTestPingFunc(sequence map[string][]bool) func(string) (bool, error) {
 	}
 
 	var m sync.Mutex
-	return func(host string) (bool, error) {
+	return func(host string) (up bool, err error) {
 		defer func() {
 			m.Unlock()
 			recover()
 		}()
 
 		m.Lock()
-		value := sequence[host][i[host]]
+		err = errTestPing
+		up = sequence[host][i[host]]
 		i[host]++
-		return value, nil
+		if up {
+			err = nil
+		}
+		return up, err
 	}
 }
 
+var errTestPing = errors.New("test ping failed")
+
 // NewTestReceiverFunc gets 2 Hosts channel and returns a function that
 // forwards whatever is put into those channels into the system injected
 // start and stop channels
 func NewTestReceiverFunc(startFW, stopFW <-chan HostStatus) Receiver {
-	return func(startCh, stopCh chan<- HostStatus) {
+	return func(ctx context.Context, startCh, stopCh chan<- HostStatus) {
 		for {
 			select {
 			case s := <-startFW:
 				startCh <- s
 			case s := <-stopFW:
 				stopCh <- s
+			case <-ctx.Done():
+				return
 			}
 		}
 	}
@@ -126,8 +807,7 @@ func NewTestReceiverFunc(startFW, stopFW <-chan HostStatus) Receiver {
 // forwards whatever is put into the system's notify channel
 func NewTestNotifyFunc(notifyFw chan<- HostStatus) Notifier {
 	return func(notify <-chan HostStatus) {
-		for {
-			value := <-notify
+		for value := range notify {
 			notifyFw <- value
 		}
 	}
@@ -137,9 +817,13 @@ func NewTestNotifyFunc(notifyFw chan<- HostStatus) Notifier {
 // function that when called will insert them as Host structs into the
 // start channel at boot time.
 func NewLoaderFunc(hosts []string) Loader {
-	return func(load chan<- HostStatus) {
+	return func(ctx context.Context, load chan<- HostStatus) {
 		for _, host := range hosts {
-			load <- HostStatus{host, false}
+			select {
+			case load <- HostStatus{Host: host, Down: false}:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
@@ -0,0 +1,87 @@
+package pingd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownScheme is returned when a host has a scheme
+// without a checker on the registry
+var ErrUnknownScheme = errors.New("unknown scheme")
+
+// Registry routes each host to a checker by the scheme of the host
+// string, eg: tcp://example.com:22. Hosts without a scheme are icmp.
+// The checkers get the whole host string, scheme included.
+type Registry struct {
+	lock     sync.RWMutex
+	checkers map[string]Checker
+}
+
+// NewRegistry returns a registry with the checkers of this package:
+// icmp, http, https, tcp and dns
+func NewRegistry() *Registry {
+	r := &Registry{checkers: make(map[string]Checker)}
+	r.Register("icmp", CheckerFunc(CheckICMP))
+	r.Register("http", CheckerFunc(CheckHTTP))
+	r.Register("https", CheckerFunc(CheckHTTP))
+	r.Register("tcp", CheckerFunc(CheckTCP))
+	r.Register("dns", CheckerFunc(CheckDNS))
+
+	return r
+}
+
+// Register sets the checker for the hosts with scheme,
+// replacing the previous one if any
+func (r *Registry) Register(scheme string, checker Checker) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.checkers[strings.ToLower(scheme)] = checker
+}
+
+// Lookup returns the checker for host
+func (r *Registry) Lookup(host string) (Checker, error) {
+	scheme := Scheme(host)
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	checker, ok := r.checkers[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownScheme, scheme)
+	}
+
+	return checker, nil
+}
+
+// Check checks host with the checker of its scheme
+func (r *Registry) Check(ctx context.Context, host string) Result {
+	checker, err := r.Lookup(host)
+	if err != nil {
+		return Result{Err: err}
+	}
+
+	return checker.Check(ctx, host)
+}
+
+// Scheme returns the lowercased scheme of host, icmp if it has none
+func Scheme(host string) string {
+	i := strings.Index(host, "://")
+	if i <= 0 {
+		return "icmp"
+	}
+
+	return strings.ToLower(host[:i])
+}
+
+// trimScheme returns host without its scheme
+func trimScheme(host string) string {
+	if i := strings.Index(host, "://"); i >= 0 {
+		return host[i+3:]
+	}
+
+	return host
+}
@@ -0,0 +1,103 @@
+package pingd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+var schemetests = []struct {
+	host, scheme string
+}{
+	{"8.8.8.8", "icmp"},
+	{"::1", "icmp"},
+	{"icmp://example.com", "icmp"},
+	{"HTTPS://example.com/health", "https"},
+	{"tcp://example.com:22", "tcp"},
+	{"dns://8.8.8.8/example.com", "dns"},
+	{"://example.com", "icmp"},
+}
+
+func TestScheme(t *testing.T) {
+	for _, tt := range schemetests {
+		if scheme := Scheme(tt.host); scheme != tt.scheme {
+			t.Errorf("Got scheme: %q for %s, expected: %q", scheme, tt.host, tt.scheme)
+		}
+	}
+}
+
+func TestICMPHost(t *testing.T) {
+	for host, expected := range map[string]string{
+		"example.com":          "example.com",
+		"icmp://example.com":   "example.com",
+		"2001:db8::1":          "2001:db8::1",
+		"icmp://[2001:db8::1]": "2001:db8::1",
+	} {
+		if h := icmpHost(host); h != expected {
+			t.Errorf("Got host: %q for %s, expected: %q", h, host, expected)
+		}
+	}
+}
+
+// TestRegistry tests that hosts are routed to the checker of their scheme
+func TestRegistry(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	checked := make(chan string, 1)
+	fake := CheckerFunc(func(ctx context.Context, host string) Result {
+		select {
+		case checked <- host:
+		default:
+		}
+		return Result{Up: true}
+	})
+
+	registry := NewRegistry()
+	registry.Register("FAKE", fake)
+	registry.Register("icmp", fake)
+
+	if r := registry.Check(context.Background(), "fake://h1"); !r.Up || <-checked != "fake://h1" {
+		t.Errorf("Got result: %+v, expected the registered checker", r)
+	}
+	if r := registry.Check(context.Background(), "h2"); !r.Up || <-checked != "h2" {
+		t.Errorf("Got result: %+v, expected the icmp checker", r)
+	}
+	if r := registry.Check(context.Background(), "gopher://h3"); !errors.Is(r.Err, ErrUnknownScheme) {
+		t.Errorf("Got result: %+v, expected: %v", r, ErrUnknownScheme)
+	}
+
+	pool := &Pool{Interval: time.Millisecond, FailLimit: 1, Checker: registry}
+	pool.Start()
+	defer pool.Shutdown(context.Background())
+
+	if err := pool.Add(HostStatus{Host: "gopher://h3"}); !errors.Is(err, ErrUnknownScheme) {
+		t.Errorf("Got add error: %v, expected: %v", err, ErrUnknownScheme)
+	}
+	if err := pool.Add(HostStatus{Host: "fake://h4"}); err != nil {
+		t.Errorf("Got add error: %v, expected: nil", err)
+	}
+	if host := <-checked; host != "fake://h4" {
+		t.Errorf("Got check of: %s, expected: fake://h4", host)
+	}
+}
+
+func TestCheckTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	if r := CheckTCP(context.Background(), "tcp://"+addr); !r.Up || r.Err != nil || r.RTT <= 0 {
+		t.Errorf("Got result: %+v, expected up", r)
+	}
+
+	l.Close()
+	if r := CheckTCP(context.Background(), "tcp://"+addr); r.Up || r.Err == nil {
+		t.Errorf("Got result: %+v, expected down", r)
+	}
+}
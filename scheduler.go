@@ -0,0 +1,310 @@
+package pingd
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DefaultWorkers is the number of concurrent pings
+// of a pool that doesn't set Workers
+const DefaultWorkers = 256
+
+// jitter is the fraction of the interval randomly added
+// or removed from each check time so hosts don't align
+const jitter = 0.05
+
+// SchedulerStats tells how loaded the pool is, a growing Due
+// or Lag means the workers can't keep up with the checks.
+type SchedulerStats struct {
+	Hosts    int           // monitors being scheduled
+	Due      int           // checks past their time waiting for a worker
+	InFlight int           // checks running
+	Workers  int           // max checks running at once
+	Lag      time.Duration // delay behind schedule of the last check started
+}
+
+// scheduler runs the checks of all monitors using a single timer
+// and a fixed number of workers. Each monitor is kept in a heap
+// ordered by its next check time.
+type scheduler struct {
+	workers int
+	check   func(context.Context, *Monitor)
+
+	lock     sync.Mutex // protects all fields below
+	queue    checkQueue
+	entries  map[*Monitor]*entry
+	inFlight int
+	lag      time.Duration
+
+	wake chan struct{}
+	free chan struct{} // one token per busy worker
+	jobs chan *entry
+	wg   sync.WaitGroup
+}
+
+// entry is a monitor on the scheduler, its checks run with ctx
+// which is cancelled when the monitor is removed.
+type entry struct {
+	m       *Monitor
+	ctx     context.Context
+	cancel  context.CancelFunc
+	next    time.Time
+	index   int  // position on the queue, -1 when not queued
+	running bool // being checked by a worker
+	removed bool
+}
+
+func newScheduler(workers int, check func(context.Context, *Monitor)) *scheduler {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	return &scheduler{
+		workers: workers,
+		check:   check,
+		entries: make(map[*Monitor]*entry),
+		wake:    make(chan struct{}, 1),
+		free:    make(chan struct{}, workers),
+		jobs:    make(chan *entry, workers),
+	}
+}
+
+// start launches the dispatcher and the workers which run until
+// ctx is done. Checks already running are not cancelled with ctx.
+func (s *scheduler) start(ctx context.Context) {
+	s.wg.Add(s.workers + 1)
+	for i := 0; i < s.workers; i++ {
+		go s.work()
+	}
+	go s.dispatch(ctx)
+}
+
+// wait blocks until the dispatcher and all the workers have exited,
+// which means no more checks are running.
+func (s *scheduler) wait() {
+	s.wg.Wait()
+}
+
+// add schedules the monitor with its first check at a random time within
+// interval, so hosts added together are spread over it. If the monitor was
+// already scheduled it's rescheduled, unless it's being checked right now,
+// then it will be rescheduled once done.
+func (s *scheduler) add(m *Monitor, interval time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, exists := s.entries[m]
+	if !exists {
+		e = &entry{m: m, index: -1}
+		e.ctx, e.cancel = context.WithCancel(context.Background())
+		s.entries[m] = e
+	}
+
+	if e.running {
+		return
+	}
+
+	e.next = time.Now().Add(time.Duration(rand.Int63n(int64(interval) + 1)))
+	if e.index < 0 {
+		heap.Push(&s.queue, e)
+	} else {
+		heap.Fix(&s.queue, e.index)
+	}
+	s.notify()
+}
+
+// remove stops scheduling checks for the monitor
+// and cancels the one running, if any.
+func (s *scheduler) remove(m *Monitor) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, exists := s.entries[m]
+	if !exists {
+		return
+	}
+
+	e.removed = true
+	e.cancel()
+	if e.index >= 0 {
+		heap.Remove(&s.queue, e.index)
+	}
+	delete(s.entries, m)
+}
+
+// stats returns the current scheduler load
+func (s *scheduler) stats() SchedulerStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	due := 0
+	for _, e := range s.queue {
+		if !e.next.After(now) {
+			due++
+		}
+	}
+
+	return SchedulerStats{
+		Hosts:    len(s.entries),
+		Due:      due,
+		InFlight: s.inFlight,
+		Workers:  s.workers,
+		Lag:      s.lag,
+	}
+}
+
+// notify wakes up the dispatcher so it sees changes
+// on the queue, must be called holding s.lock.
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch hands the due checks to the workers as they become free
+// and sleeps until the next one is due.
+func (s *scheduler) dispatch(ctx context.Context) {
+	defer s.wg.Done()
+	defer close(s.jobs)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.lock.Lock()
+		wait := time.Hour
+		if len(s.queue) > 0 {
+			wait = time.Until(s.queue[0].next)
+		}
+		s.lock.Unlock()
+
+		if wait <= 0 {
+			// wait for a free worker before taking the
+			// check out of the queue, so it can still be
+			// removed or rescheduled in the meantime
+			select {
+			case s.free <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			if e := s.take(); e != nil {
+				s.jobs <- e
+			} else {
+				<-s.free
+			}
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// work runs checks until the dispatcher stops
+func (s *scheduler) work() {
+	defer s.wg.Done()
+
+	for e := range s.jobs {
+		s.check(e.ctx, e.m)
+		s.done(e)
+		<-s.free
+	}
+}
+
+// take pops the next due check off the queue, if it's
+// still due after waiting for the worker.
+func (s *scheduler) take() *entry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.queue) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	e := s.queue[0]
+	if e.next.After(now) {
+		return nil
+	}
+
+	heap.Pop(&s.queue)
+	e.running = true
+	s.inFlight++
+	s.lag = now.Sub(e.next)
+
+	return e
+}
+
+// done puts a checked monitor back on the queue for its next check,
+// one interval after the last one was due plus some jitter. If the
+// workers are running late, the check is due right away but the
+// missed ones are not made up for.
+func (s *scheduler) done(e *entry) {
+	interval := e.m.Status().Settings.Interval
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e.running = false
+	s.inFlight--
+	if e.removed {
+		return
+	}
+
+	spread := int64(float64(interval) * jitter)
+	next := e.next.Add(interval + time.Duration(rand.Int63n(2*spread+1)-spread))
+	if now := time.Now(); next.Before(now) {
+		next = now
+	}
+	e.next = next
+
+	heap.Push(&s.queue, e)
+	s.notify()
+}
+
+// checkQueue is a heap of entries ordered by next check time
+type checkQueue []*entry
+
+func (q checkQueue) Len() int { return len(q) }
+
+func (q checkQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+
+func (q checkQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *checkQueue) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *checkQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*q = old[:n-1]
+	return e
+}
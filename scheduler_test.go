@@ -0,0 +1,146 @@
+package pingd
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSchedulerSpread tests that checks are spread over the interval
+// and never run more than the number of workers at once. It looks at
+// the times the scheduler has the checks due, not at when they run,
+// so a loaded machine only makes it slower.
+func TestSchedulerSpread(t *testing.T) {
+	const hosts, workers = 100, 4
+	interval := 100 * time.Millisecond
+
+	var sched *scheduler
+	var lock sync.Mutex
+	var running, maxRunning int
+	due := make(map[*Monitor][]time.Time)
+	checked := make(chan struct{}, hosts)
+
+	sched = newScheduler(workers, func(ctx context.Context, m *Monitor) {
+		sched.lock.Lock()
+		next := sched.entries[m].next
+		sched.lock.Unlock()
+
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		due[m] = append(due[m], next)
+		if len(due[m]) == 2 {
+			checked <- struct{}{}
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+	})
+
+	// the times before and after each host was added
+	added := make(map[*Monitor][2]time.Time, hosts)
+	for i := 0; i < hosts; i++ {
+		m := NewMonitor(HostStatus{}, nil, nil)
+		m.configure(Settings{Interval: interval}, nil)
+		before := time.Now()
+		sched.add(m, interval)
+		added[m] = [2]time.Time{before, time.Now()}
+	}
+
+	if stats := sched.stats(); stats.Hosts != hosts || stats.Workers != workers {
+		t.Errorf("Got stats: %+v, expected %d hosts and %d workers", stats, hosts, workers)
+	}
+
+	// first checks should cover the interval, not happen all at once
+	var early, late int
+	sched.lock.Lock()
+	for m, e := range sched.entries {
+		first := e.next.Sub(added[m][0])
+		if first < 0 || e.next.After(added[m][1].Add(interval)) {
+			t.Errorf("Got first check %s after adding, expected within %s", first, interval)
+		}
+		if first < interval/2 {
+			early++
+		} else {
+			late++
+		}
+	}
+	sched.lock.Unlock()
+	if early < hosts/4 || late < hosts/4 {
+		t.Errorf("Got %d early and %d late first checks out of %d, expected them spread", early, late, hosts)
+	}
+
+	// every host checked twice
+	ctx, cancel := context.WithCancel(context.Background())
+	sched.start(ctx)
+	timeout := time.After(10 * time.Second)
+wait:
+	for i := 0; i < hosts; i++ {
+		select {
+		case <-checked:
+		case <-timeout:
+			t.Errorf("Got %d hosts checked twice, expected %d", i, hosts)
+			break wait
+		}
+	}
+	cancel()
+	sched.wait()
+
+	if maxRunning > workers {
+		t.Errorf("Got %d checks running at once, expected at most %d", maxRunning, workers)
+	}
+
+	// late checks are due right away, but never before an interval
+	min := interval - time.Duration(float64(interval)*jitter)
+	for m, times := range due {
+		for i := 1; i < len(times); i++ {
+			if d := times[i].Sub(times[i-1]); d < min {
+				t.Errorf("Got checks of monitor %p due %s apart, expected at least %s", m, d, min)
+				break
+			}
+		}
+	}
+}
+
+// TestSchedulerOverload tests that the stats show when
+// the workers can't keep up with the checks
+func TestSchedulerOverload(t *testing.T) {
+	sched := newScheduler(1, func(ctx context.Context, m *Monitor) {
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sched.start(ctx)
+	defer sched.wait()
+	defer cancel()
+
+	var removed *Monitor
+	for i := 0; i < 10; i++ {
+		removed = NewMonitor(HostStatus{}, nil, nil)
+		removed.configure(Settings{Interval: time.Millisecond}, nil)
+		sched.add(removed, time.Millisecond)
+	}
+	sched.remove(removed)
+
+	// the lag only grows, a loaded machine gets there later
+	stats := sched.stats()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); stats = sched.stats() {
+		if stats.Due > 0 && stats.Lag >= 10*time.Millisecond {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if stats.Hosts != 9 || stats.InFlight > 1 {
+		t.Errorf("Got stats: %+v, expected 9 hosts and at most 1 check in flight", stats)
+	}
+	if stats.Due == 0 || stats.Lag < 10*time.Millisecond {
+		t.Errorf("Got stats: %+v, expected due checks and lag", stats)
+	}
+}
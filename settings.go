@@ -0,0 +1,204 @@
+package pingd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknownCheck is returned when a host asks for a check
+// that is not available in the pool
+var ErrUnknownCheck = errors.New("unknown check")
+
+// Settings are the per host check parameters, zero
+// values are replaced by the pool defaults.
+type Settings struct {
+	Interval     time.Duration // time between pings
+	FailLimit    int           // failed pings in a row to consider the host down
+	RecoverLimit int           // successful pings in a row to consider the host up again
+	Timeout      time.Duration // single ping timeout, 0 means no timeout
+	Check        string        // name of the checker on Pool.Checks, empty uses Pool.Checker
+	FlapWindow   time.Duration // period in which FlapLimit status changes mean the host is flapping
+	FlapLimit    int           // status changes within FlapWindow to consider the host flapping
+	Parents      []string      // hosts this one is reached through, eg: its gateway
+	Tags         []string      // labels to match the host by, see Silence
+	MaxRTT       time.Duration // RTT over which the host is degraded, 0 means no limit
+	MaxLoss      float64       // loss percentage over which the host is degraded, 0 means no limit
+	DegradeLimit int           // checks in a row over or under the limits to change degraded status
+	MinMTU       int           // path MTU under which the host is degraded, 0 means no limit
+
+	// ICMP echoes of the host, zero values leave the ones of the checker
+	PayloadSize  int    // payload bytes
+	TTL          int    // see ping.Header
+	TOS          int    // see ping.Header
+	DontFragment bool   // fail instead of fragmenting echoes over the path MTU
+	Source       string // address or interface to send from, empty for any
+}
+
+type settingsKey struct{}
+
+// HostSettings returns the settings of the host being checked, the
+// pool passes them to Checker.Check on ctx so a checker can take its
+// parameters from them, eg: CheckICMP and the ICMP echo settings.
+func HostSettings(ctx context.Context) (Settings, bool) {
+	s, ok := ctx.Value(settingsKey{}).(Settings)
+	return s, ok
+}
+
+// withSettings returns ctx carrying s, see HostSettings
+func withSettings(ctx context.Context, s Settings) context.Context {
+	return context.WithValue(ctx, settingsKey{}, s)
+}
+
+// Set parses value and assigns it to the setting called name,
+// names are the ones used by the receivers:
+// interval, failLimit, recoverLimit, timeout, check, flapWindow, flapLimit
+// parents and tags, both comma separated lists, maxRTT, maxLoss,
+// degradeLimit, minMTU, payloadSize, ttl, tos or dscp (tos >> 2),
+// dontFragment and source.
+func (s *Settings) Set(name, value string) error {
+	var err error
+	switch name {
+	case "interval":
+		s.Interval, err = parseDuration(value)
+	case "failLimit":
+		s.FailLimit, err = parseLimit(value)
+	case "recoverLimit":
+		s.RecoverLimit, err = parseLimit(value)
+	case "timeout":
+		s.Timeout, err = parseDuration(value)
+	case "check":
+		s.Check = value
+	case "flapWindow":
+		s.FlapWindow, err = parseDuration(value)
+	case "flapLimit":
+		s.FlapLimit, err = parseLimit(value)
+	case "parents":
+		s.Parents = parseList(value)
+	case "tags":
+		s.Tags = parseList(value)
+	case "maxRTT":
+		s.MaxRTT, err = parseDuration(value)
+	case "maxLoss":
+		s.MaxLoss, err = parsePercent(value)
+	case "degradeLimit":
+		s.DegradeLimit, err = parseLimit(value)
+	case "minMTU":
+		s.MinMTU, err = parseRange(value, 0, 65535)
+	case "payloadSize":
+		s.PayloadSize, err = parseRange(value, 1, 65000)
+	case "ttl":
+		s.TTL, err = parseRange(value, 1, 255)
+	case "tos":
+		s.TOS, err = parseRange(value, 0, 255)
+	case "dscp":
+		var dscp int
+		dscp, err = parseRange(value, 0, 63)
+		s.TOS = dscp << 2
+	case "dontFragment":
+		s.DontFragment, err = strconv.ParseBool(value)
+	case "source":
+		s.Source = value
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return nil
+}
+
+// zero tells whether none of the settings is set
+func (s Settings) zero() bool {
+	empty := len(s.Parents) == 0 && len(s.Tags) == 0
+	s.Parents, s.Tags = nil, nil
+	return empty && reflect.DeepEqual(s, Settings{})
+}
+
+// merge returns s with the zero values taken from defaults
+func (s Settings) merge(defaults Settings) Settings {
+	if s.Interval == 0 {
+		s.Interval = defaults.Interval
+	}
+	if s.FailLimit == 0 {
+		s.FailLimit = defaults.FailLimit
+	}
+	if s.RecoverLimit == 0 {
+		s.RecoverLimit = defaults.RecoverLimit
+	}
+	if s.Timeout == 0 {
+		s.Timeout = defaults.Timeout
+	}
+	if s.Check == "" {
+		s.Check = defaults.Check
+	}
+	if s.FlapWindow == 0 {
+		s.FlapWindow = defaults.FlapWindow
+	}
+	if s.FlapLimit == 0 {
+		s.FlapLimit = defaults.FlapLimit
+	}
+	if s.MaxRTT == 0 {
+		s.MaxRTT = defaults.MaxRTT
+	}
+	if s.MaxLoss == 0 {
+		s.MaxLoss = defaults.MaxLoss
+	}
+	if s.DegradeLimit == 0 {
+		s.DegradeLimit = defaults.DegradeLimit
+	}
+	if s.MinMTU == 0 {
+		s.MinMTU = defaults.MinMTU
+	}
+
+	return s
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err == nil && d < 0 {
+		err = errors.New("must not be negative")
+	}
+	return d, err
+}
+
+func parsePercent(value string) (float64, error) {
+	p, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err == nil && (p < 0 || p > 100) {
+		err = errors.New("must be between 0 and 100")
+	}
+	return p, err
+}
+
+func parseList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseRange parses an integer between min and max,
+// in hexadecimal with a 0x prefix
+func parseRange(value string, min, max int) (int, error) {
+	n, err := strconv.ParseInt(value, 0, 0)
+	if err == nil && (n < int64(min) || n > int64(max)) {
+		err = fmt.Errorf("must be between %d and %d", min, max)
+	}
+	return int(n), err
+}
+
+func parseLimit(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err == nil && n < 1 {
+		err = errors.New("must be at least 1")
+	}
+	return n, err
+}
@@ -0,0 +1,279 @@
+package pingd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidSilence is returned when adding a silence
+	// that doesn't match any host or has no valid window
+	ErrInvalidSilence = errors.New("invalid silence")
+
+	// ErrSilenceNotFound is returned when removing an unknown silence
+	ErrSilenceNotFound = errors.New("silence not found")
+)
+
+// Silence holds back the events of the matching hosts during a
+// time window, eg: while they're being rebooted. The hosts keep
+// being checked, see HostStatus.Silenced. If Every is set the
+// window repeats, eg: every 24h for a nightly maintenance.
+type Silence struct {
+	ID      string        `json:"id"`
+	Host    string        `json:"host,omitempty"` // host name or glob, eg: *.example.com
+	Tag     string        `json:"tag,omitempty"`  // one of Settings.Tags
+	Start   time.Time     `json:"start"`
+	End     time.Time     `json:"end"`
+	Every   time.Duration `json:"every,omitempty"` // time between the start of each window
+	Comment string        `json:"comment,omitempty"`
+}
+
+// Set parses value and assigns it to the field called name, names are
+// the ones used by the receivers: host, tag, start, end, duration, every
+// and comment. Times are RFC 3339, duration sets End after Start.
+func (s *Silence) Set(name, value string) error {
+	var err error
+	switch name {
+	case "host":
+		s.Host = value
+	case "tag":
+		s.Tag = value
+	case "start":
+		s.Start, err = time.Parse(time.RFC3339, value)
+	case "end":
+		s.End, err = time.Parse(time.RFC3339, value)
+	case "duration":
+		var d time.Duration
+		if d, err = parseDuration(value); err == nil {
+			s.End = s.Start.Add(d)
+		}
+	case "every":
+		s.Every, err = parseDuration(value)
+	case "comment":
+		s.Comment = value
+	default:
+		return fmt.Errorf("unknown silence field %q", name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return nil
+}
+
+// ParseSilence returns the silence with the fields given as name and
+// value set, see Silence.Set. The start is now if not given, and the
+// duration is applied after it whatever the order of fields.
+func ParseSilence(fields map[string]string) (Silence, error) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		// duration last, the rest sorted so errors are always the same
+		if (names[i] == "duration") != (names[j] == "duration") {
+			return names[j] == "duration"
+		}
+		return names[i] < names[j]
+	})
+
+	s := Silence{Start: time.Now().Truncate(time.Second)}
+	for _, name := range names {
+		if err := s.Set(name, fields[name]); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// validate tells what's wrong with the silence
+func (s Silence) validate() error {
+	if s.Host == "" && s.Tag == "" {
+		return fmt.Errorf("%w: no host or tag", ErrInvalidSilence)
+	}
+	if _, err := path.Match(s.Host, ""); err != nil {
+		return fmt.Errorf("%w: host: %v", ErrInvalidSilence, err)
+	}
+	if !s.End.After(s.Start) {
+		return fmt.Errorf("%w: end must be after start", ErrInvalidSilence)
+	}
+	if s.Every != 0 && s.Every <= s.End.Sub(s.Start) {
+		return fmt.Errorf("%w: every must be longer than the window", ErrInvalidSilence)
+	}
+	return nil
+}
+
+// matches tells whether the silence covers the host with tags at t
+func (s Silence) matches(host string, tags []string, t time.Time) bool {
+	if s.Host != "" {
+		if ok, _ := path.Match(s.Host, host); !ok {
+			return false
+		}
+	}
+	if s.Tag != "" && !contains(tags, s.Tag) {
+		return false
+	}
+
+	if t.Before(s.Start) {
+		return false
+	}
+	if s.Every == 0 {
+		return t.Before(s.End)
+	}
+	return t.Sub(s.Start)%s.Every < s.End.Sub(s.Start)
+}
+
+// expired tells whether the silence won't match anymore after t
+func (s Silence) expired(t time.Time) bool {
+	return s.Every == 0 && !t.Before(s.End)
+}
+
+// Silences is the set of silences of a pool, stored
+// in a JSON file so they survive restarts.
+type Silences struct {
+	file string
+
+	lock     sync.Mutex
+	silences map[string]Silence
+}
+
+// NewSilences returns the silences stored in file, which is created on
+// the first change if it doesn't exist. With no file they aren't stored.
+func NewSilences(file string) (*Silences, error) {
+	s := &Silences{file: file, silences: make(map[string]Silence)}
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Silence
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("reading silences from %s: %v", file, err)
+	}
+	for _, silence := range list {
+		s.silences[silence.ID] = silence
+	}
+
+	return s, nil
+}
+
+// Add stores a new silence and returns it with its ID, the
+// silences which have already expired are dropped.
+func (s *Silences) Add(silence Silence) (Silence, error) {
+	if err := silence.validate(); err != nil {
+		return silence, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return silence, err
+	}
+	silence.ID = hex.EncodeToString(id)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	for id, old := range s.silences {
+		if old.expired(now) {
+			delete(s.silences, id)
+		}
+	}
+	s.silences[silence.ID] = silence
+
+	return silence, s.save()
+}
+
+// Remove deletes the silence with id, its hosts are
+// notified of the changes during the silence if any.
+func (s *Silences) Remove(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, exists := s.silences[id]; !exists {
+		return ErrSilenceNotFound
+	}
+	delete(s.silences, id)
+
+	return s.save()
+}
+
+// List returns the silences sorted by start
+func (s *Silences) List() []Silence {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]Silence, 0, len(s.silences))
+	for _, silence := range s.silences {
+		list = append(list, silence)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Start.Equal(list[j].Start) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].Start.Before(list[j].Start)
+	})
+
+	return list
+}
+
+// active tells whether any silence covers the host with tags at t
+func (s *Silences) active(host string, tags []string, t time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, silence := range s.silences {
+		if silence.matches(host, tags, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// save writes all the silences to the file replacing it
+// at once, must be called holding s.lock.
+func (s *Silences) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	list := make([]Silence, 0, len(s.silences))
+	for _, silence := range s.silences {
+		list = append(list, silence)
+	}
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package pingd
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var windowStart = time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC)
+
+var matchtests = []struct {
+	silence Silence
+	host    string
+	tags    []string
+	at      time.Time
+	match   bool
+}{
+	{Silence{Host: "h1", Start: windowStart, End: windowStart.Add(time.Hour)}, "h1", nil, windowStart, true},
+	{Silence{Host: "h1", Start: windowStart, End: windowStart.Add(time.Hour)}, "h2", nil, windowStart, false},
+	{Silence{Host: "h1", Start: windowStart, End: windowStart.Add(time.Hour)}, "h1", nil, windowStart.Add(-time.Second), false},
+	{Silence{Host: "h1", Start: windowStart, End: windowStart.Add(time.Hour)}, "h1", nil, windowStart.Add(time.Hour), false},
+	{Silence{Host: "*.example.com", Start: windowStart, End: windowStart.Add(time.Hour)}, "db.example.com", nil, windowStart, true},
+	{Silence{Host: "*.example.com", Start: windowStart, End: windowStart.Add(time.Hour)}, "example.com", nil, windowStart, false},
+	{Silence{Tag: "db", Start: windowStart, End: windowStart.Add(time.Hour)}, "h1", []string{"web", "db"}, windowStart, true},
+	{Silence{Tag: "db", Start: windowStart, End: windowStart.Add(time.Hour)}, "h1", []string{"web"}, windowStart, false},
+	{Silence{Host: "h1", Tag: "db", Start: windowStart, End: windowStart.Add(time.Hour)}, "h2", []string{"db"}, windowStart, false},
+	{Silence{Host: "h1", Start: windowStart, End: windowStart.Add(time.Hour), Every: 24 * time.Hour}, "h1", nil, windowStart.Add(48*time.Hour + 30*time.Minute), true},
+	{Silence{Host: "h1", Start: windowStart, End: windowStart.Add(time.Hour), Every: 24 * time.Hour}, "h1", nil, windowStart.Add(49 * time.Hour), false},
+}
+
+func TestSilenceMatches(t *testing.T) {
+	for i, tt := range matchtests {
+		if match := tt.silence.matches(tt.host, tt.tags, tt.at); match != tt.match {
+			t.Errorf("Got match: %t for test %d, expected: %t", match, i, tt.match)
+		}
+	}
+}
+
+// TestSilences tests adding, removing and storing silences
+func TestSilences(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "silences.json")
+	silences, err := NewSilences(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []Silence{
+		{Start: windowStart, End: windowStart.Add(time.Hour)},
+		{Host: "[", Start: windowStart, End: windowStart.Add(time.Hour)},
+		{Host: "h1", Start: windowStart, End: windowStart},
+		{Host: "h1", Start: windowStart, End: windowStart.Add(time.Hour), Every: time.Hour},
+	}
+	for _, silence := range invalid {
+		if _, err := silences.Add(silence); !errors.Is(err, ErrInvalidSilence) {
+			t.Errorf("Got add error: %v for %+v, expected: %v", err, silence, ErrInvalidSilence)
+		}
+	}
+
+	var silence Silence
+	for _, kv := range [][2]string{{"host", "h1"}, {"start", windowStart.Format(time.RFC3339)}, {"duration", "1h"}, {"every", "24h"}} {
+		if err := silence.Set(kv[0], kv[1]); err != nil {
+			t.Errorf("Got set error: %v for %s", err, kv[0])
+		}
+	}
+	if err := silence.Set("color", "red"); err == nil {
+		t.Error("Got no set error for an unknown field")
+	}
+
+	kept, err := silences.Add(silence)
+	if err != nil || kept.ID == "" {
+		t.Fatalf("Got silence: %+v and error: %v, expected an ID", kept, err)
+	}
+	expired, _ := silences.Add(Silence{Host: "h2", Start: windowStart, End: windowStart.Add(time.Hour)})
+	if err := silences.Remove(expired.ID); err != nil {
+		t.Errorf("Got remove error: %v, expected: nil", err)
+	}
+	if err := silences.Remove(expired.ID); err != ErrSilenceNotFound {
+		t.Errorf("Got remove error: %v, expected: %v", err, ErrSilenceNotFound)
+	}
+
+	loaded, err := NewSilences(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := loaded.List(); len(list) != 1 || list[0] != kept {
+		t.Errorf("Got silences: %+v, expected: %+v", list, kept)
+	}
+	if !loaded.active("h1", nil, windowStart.Add(24*time.Hour)) {
+		t.Error("Got h1 not silenced, expected silenced")
+	}
+}
@@ -0,0 +1,395 @@
+package pingd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultCheckpoint is the time between snapshots of
+// the Store of a pool that doesn't set Checkpoint
+const DefaultCheckpoint = time.Minute
+
+const (
+	snapshotFile = "snapshot.json"
+	logFile      = "log.jsonl"
+)
+
+// HostState is what the Store keeps of a monitored host
+type HostState struct {
+	Host       string        `json:"host"`
+	Seq        uint64        `json:"seq,omitempty"`     // only on the log, order of the change
+	Removed    bool          `json:"removed,omitempty"` // only on the log, the host is no longer monitored
+	Settings   Settings      `json:"settings"`          // as given, without the pool defaults
+	State      State         `json:"state"`
+	Held       bool          `json:"held,omitempty"`
+	Degraded   bool          `json:"degraded,omitempty"`
+	Failures   int           `json:"failures"`
+	Successes  int           `json:"successes"`
+	LastError  string        `json:"lastError,omitempty"`
+	LastCheck  time.Time     `json:"lastCheck"`
+	LastChange time.Time     `json:"lastChange"`
+	RTT        time.Duration `json:"rtt"`
+	MTU        int           `json:"mtu,omitempty"` // path MTU on the last event
+
+	Checks      []storedCheck `json:"checks,omitempty"`      // only on the snapshot
+	Transitions []Transition  `json:"transitions,omitempty"` // only the last one on the log
+	Start       time.Time     `json:"start"`
+	StartDown   bool          `json:"startDown,omitempty"`
+}
+
+// storedCheck is a CheckRecord with the error as text
+type storedCheck struct {
+	Time time.Time     `json:"time"`
+	Up   bool          `json:"up"`
+	RTT  time.Duration `json:"rtt"`
+	Err  string        `json:"err,omitempty"`
+}
+
+// snapshot is the content of the snapshot file
+type snapshot struct {
+	Seq   uint64      `json:"seq"` // of the last change it includes
+	Hosts []HostState `json:"hosts"`
+}
+
+// Store keeps the state of the hosts of a pool in a directory so
+// it's restored after a restart. The pool appends a line to the log
+// on every host change and transition, with its state and the last
+// transition, and periodically replaces the snapshot with the state
+// and history of all hosts, emptying the log. The changes are queued
+// in order holding the pool lock and written after it.
+type Store struct {
+	dir string
+
+	lock    sync.Mutex // protects pending and seq
+	pending []HostState
+	seq     uint64 // of the last change queued
+
+	write sync.Mutex // protects log, held while writing
+	log   *os.File
+}
+
+// NewStore opens the store in dir, creating it if needed
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{dir: dir, log: f}, nil
+}
+
+// Close closes the log, the store can't be used after that
+func (s *Store) Close() error {
+	s.write.Lock()
+	defer s.write.Unlock()
+
+	return s.log.Close()
+}
+
+// load returns the hosts on the snapshot with the changes of the log
+// applied, sorted by host. A truncated last line on the log, left by a
+// crash, is ignored and cut off so the next changes are not appended to it.
+// The changes already in the snapshot are skipped, they're left on the log
+// by a crash between writing the snapshot and emptying the log.
+func (s *Store) load() ([]HostState, error) {
+	s.write.Lock()
+	defer s.write.Unlock()
+
+	hosts := make(map[string]HostState)
+
+	var snap snapshot
+	b, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &snap); err != nil {
+			return nil, fmt.Errorf("reading snapshot: %v", err)
+		}
+		for _, h := range snap.Hosts {
+			hosts[h.Host] = h
+		}
+	}
+	seq := snap.Seq
+
+	f, err := os.Open(filepath.Join(s.dir, logFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var good int64 // end of the last line read
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Println("STORE ignoring a truncated line at the end of the log")
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var h HostState
+		if err := json.Unmarshal(line, &h); err != nil {
+			log.Println("STORE ignoring the rest of the log: " + err.Error())
+			break
+		}
+		good += int64(len(line))
+		if h.Seq <= snap.Seq {
+			continue
+		}
+		seq = h.Seq
+
+		stored, exists := hosts[h.Host]
+		switch {
+		case h.Removed:
+			delete(hosts, h.Host)
+		case exists:
+			hosts[h.Host] = stored.apply(h)
+		default:
+			hosts[h.Host] = h
+		}
+	}
+	if info, err := f.Stat(); err == nil && info.Size() > good {
+		if err := s.log.Truncate(good); err != nil {
+			return nil, err
+		}
+	}
+
+	s.lock.Lock()
+	s.seq = seq
+	s.lock.Unlock()
+
+	list := make([]HostState, 0, len(hosts))
+	for _, h := range hosts {
+		h.Seq = 0
+		list = append(list, h)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Host < list[j].Host })
+
+	return list, nil
+}
+
+// apply returns the stored state of a host with a change from the log,
+// the checks are the stored ones and the transition is added to them
+func (h HostState) apply(change HostState) HostState {
+	checks, transitions := h.Checks, h.Transitions
+	for _, t := range change.Transitions {
+		if n := len(transitions); n == 0 || t.Time.After(transitions[n-1].Time) {
+			transitions = append(transitions, t)
+		}
+	}
+	if len(transitions) > maxTransitions {
+		transitions = transitions[len(transitions)-maxTransitions:]
+	}
+
+	h = change
+	h.Checks, h.Transitions = checks, transitions
+	return h
+}
+
+// queue adds a change of a host to the ones to append to the log,
+// in the order they're queued, see flush
+func (s *Store) queue(h HostState) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.seq++
+	h.Seq = s.seq
+	s.pending = append(s.pending, h)
+}
+
+// flush appends the queued changes to the log
+func (s *Store) flush() error {
+	s.write.Lock()
+	defer s.write.Unlock()
+
+	s.lock.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.lock.Unlock()
+
+	var buf []byte
+	for _, h := range pending {
+		b, err := json.Marshal(h)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+
+	_, err := s.log.Write(buf)
+	return err
+}
+
+// checkpoint starts a checkpoint of the hosts being collected, it drops the
+// changes queued until now as the snapshot has them and holds back the
+// writes of the following ones until the returned function replaces the
+// snapshot with hosts. The pool calls it holding its lock so no change
+// is queued in between, and the returned function after releasing it.
+func (s *Store) checkpoint() func(hosts []HostState) error {
+	s.write.Lock()
+	s.lock.Lock()
+	s.pending = nil
+	seq := s.seq
+	s.lock.Unlock()
+
+	return func(hosts []HostState) error {
+		defer s.write.Unlock()
+		return s.replace(snapshot{Seq: seq, Hosts: hosts})
+	}
+}
+
+// replace writes the snapshot and empties the log,
+// must be called holding s.write.
+func (s *Store) replace(snap snapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+
+	return s.log.Truncate(0)
+}
+
+// keep sets the settings of the host as given,
+// without the pool defaults, to store them
+func (m *Monitor) keep(s Settings) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.own = s
+}
+
+// kept returns the settings of the host as given
+func (m *Monitor) kept() Settings {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.own
+}
+
+// save returns the state of the monitor with its history to
+// store it on the snapshot
+func (m *Monitor) save() HostState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state := m.stored()
+	h := m.history.snapshot()
+	state.Transitions = h.Transitions
+	for _, c := range h.Checks {
+		stored := storedCheck{Time: c.Time, Up: c.Up, RTT: c.RTT}
+		if c.Err != nil {
+			stored.Err = c.Err.Error()
+		}
+		state.Checks = append(state.Checks, stored)
+	}
+
+	return state
+}
+
+// change returns the state of the monitor to append it to the log,
+// of its history only the last transition as the log has the others
+func (m *Monitor) change() HostState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state := m.stored()
+	if n := len(m.history.transitions); n > 0 {
+		state.Transitions = []Transition{m.history.transitions[n-1]}
+	}
+
+	return state
+}
+
+// stored returns the state of the monitor without its
+// history, must be called holding m.lock.
+func (m *Monitor) stored() HostState {
+	state := HostState{
+		Host:       m.host,
+		Settings:   m.own,
+		State:      m.state,
+		Held:       m.held,
+		Degraded:   m.degraded,
+		Failures:   m.failures,
+		Successes:  m.successes,
+		LastCheck:  m.lastCheck,
+		LastChange: m.lastChange,
+		RTT:        m.rtt,
+		MTU:        m.toldMTU,
+		Start:      m.history.start,
+		StartDown:  m.history.startDown,
+	}
+	if m.lastErr != nil {
+		state.LastError = m.lastErr.Error()
+	}
+
+	return state
+}
+
+// restore sets the monitor back to a stored state
+func (m *Monitor) restore(state HostState) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.state = state.State
+	m.held = state.Held
+	m.degraded = state.Degraded
+	m.last = m.current()
+	m.failures = state.Failures
+	m.successes = state.Successes
+	m.lastErr = nil
+	if state.LastError != "" {
+		m.lastErr = errors.New(state.LastError)
+	}
+	m.lastCheck = state.LastCheck
+	m.lastChange = state.LastChange
+	m.rtt = state.RTT
+	m.mtu, m.toldMTU = state.MTU, state.MTU
+
+	h := m.history
+	h.start, h.startDown = state.Start, state.StartDown
+	h.transitions = state.Transitions
+	if len(h.transitions) > maxTransitions {
+		h.transitions = h.transitions[len(h.transitions)-maxTransitions:]
+	}
+	h.down = state.State.Down()
+	for _, c := range state.Checks {
+		r := CheckRecord{Time: c.Time, Up: c.Up, RTT: c.RTT}
+		if c.Err != "" {
+			r.Err = errors.New(c.Err)
+		}
+		h.checks[h.next] = r
+		h.next = (h.next + 1) % len(h.checks)
+		if h.next == 0 {
+			h.full = true
+		}
+	}
+}
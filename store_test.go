@@ -0,0 +1,165 @@
+package pingd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t1, t2 := time.Now(), time.Now().Add(time.Second)
+	store.queue(HostState{Host: "h0"})
+	store.checkpoint()([]HostState{{Host: "h1", Failures: 1, Checks: []storedCheck{{Err: "refused"}}, Transitions: []Transition{{Time: t1}}}, {Host: "h2"}})
+	store.queue(HostState{Host: "h1", Failures: 2, Transitions: []Transition{{Time: t1}}})
+	store.queue(HostState{Host: "h1", Failures: 2, Transitions: []Transition{{Time: t2, Down: true}}})
+	store.queue(HostState{Host: "h2", Removed: true})
+	store.flush()
+	store.queue(HostState{Host: "h3"})
+	store.flush()
+	store.Close()
+
+	// a crash while appending leaves a truncated line
+	f, _ := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"host":"h4","fail`)
+	f.Close()
+
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if states, _ := store.load(); len(states) != 2 {
+		t.Fatalf("Got states: %+v, expected h1 and h3", states)
+	}
+
+	// the changes after the restart are not appended to the truncated line
+	store.queue(HostState{Host: "h5"})
+	store.flush()
+	store.Close()
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	states, err := store.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 3 || states[0].Host != "h1" || states[0].Failures != 2 || states[1].Host != "h3" || states[2].Host != "h5" {
+		t.Fatalf("Got states: %+v, expected h1 with the log changes, h3 and h5", states)
+	}
+	if states[0].Checks[0].Err != "refused" {
+		t.Errorf("Got checks: %+v, expected the ones of the snapshot", states[0].Checks)
+	}
+	if tr := states[0].Transitions; len(tr) != 2 || !tr[0].Time.Equal(t1) || !tr[1].Time.Equal(t2) || !tr[1].Down {
+		t.Errorf("Got transitions: %+v, expected the snapshot one and the new one", tr)
+	}
+
+	old, _ := os.ReadFile(filepath.Join(dir, logFile))
+	states[0].Failures = 3
+	store.checkpoint()(states)
+	if info, _ := os.Stat(filepath.Join(dir, logFile)); info.Size() != 0 {
+		t.Errorf("Got log size: %d, expected emptied by the checkpoint", info.Size())
+	}
+
+	// a crash before emptying the log leaves the changes in the snapshot
+	os.WriteFile(filepath.Join(dir, logFile), old, 0644)
+	again, _ := store.load()
+	if len(again) != 3 || again[0].Failures != 3 || len(again[0].Transitions) != 2 {
+		t.Errorf("Got states: %+v, expected the same after the checkpoint", again)
+	}
+
+	// the changes go on after the ones skipped
+	store.queue(HostState{Host: "h6"})
+	store.flush()
+	if again, _ := store.load(); len(again) != 4 || again[3].Host != "h6" {
+		t.Errorf("Got states: %+v, expected h6 added", again)
+	}
+}
+
+// TestPoolStore tests a pool restarted while a host is down
+// restores it without notifying it as down again
+func TestPoolStore(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	dir := t.TempDir()
+	down := CheckerFunc(func(ctx context.Context, host string) Result {
+		return Result{Err: errors.New("refused")}
+	})
+
+	store, _ := NewStore(dir)
+	notifyCh := make(chan HostStatus, 10)
+	pool := &Pool{
+		Interval:  time.Millisecond,
+		FailLimit: 2,
+		Checker:   down,
+		Notify:    NewTestNotifyFunc(notifyCh),
+		Store:     store,
+	}
+	pool.Start()
+	pool.Add(HostStatus{Host: "h1", Settings: Settings{Tags: []string{"db"}}})
+	if h := <-notifyCh; !h.Down {
+		t.Fatalf("Got event: %s, expected down", h)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, logFile)); !bytes.Contains(b, []byte(`"transitions"`)) || bytes.Contains(b, []byte(`"checks"`)) {
+		t.Errorf("Got log:\n%s\nexpected the transitions without the checks", b)
+	}
+	pool.Shutdown(context.Background())
+	store.Close()
+
+	store, _ = NewStore(dir)
+	defer store.Close()
+	checked := make(chan struct{}, 10)
+	pool = &Pool{
+		Interval:  time.Millisecond,
+		FailLimit: 2,
+		Checker: CheckerFunc(func(ctx context.Context, host string) Result {
+			checked <- struct{}{}
+			return down(ctx, host)
+		}),
+		Notify: NewTestNotifyFunc(notifyCh),
+		Store:  store,
+	}
+	pool.Start()
+	defer pool.Shutdown(context.Background())
+
+	<-checked
+	<-checked
+	status, err := pool.Status("h1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != StateDown || status.Settings.Tags[0] != "db" || status.Settings.Interval != time.Millisecond {
+		t.Errorf("Got status: %+v, expected down with its settings", status)
+	}
+	if h, _ := pool.History("h1"); len(h.Transitions) != 1 || !h.Transitions[0].Down {
+		t.Errorf("Got transitions: %+v, expected the one before the restart", h.Transitions)
+	}
+
+	// like a Loader adding it again without settings
+	pool.Add(HostStatus{Host: "h1"})
+	if status, _ := pool.Status("h1"); len(status.Settings.Tags) != 1 || status.Settings.Tags[0] != "db" {
+		t.Errorf("Got settings: %+v, expected the restored ones", status.Settings)
+	}
+
+	select {
+	case h := <-notifyCh:
+		t.Errorf("Got event: %s, expected none after the restart", h)
+	default:
+	}
+}
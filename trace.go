@@ -0,0 +1,45 @@
+package pingd
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/pinggg/pingd/ping"
+)
+
+// DefaultTraceTimeout is how long the traceroute of a host going
+// down may delay its DOWN event when Pool.TraceTimeout is not set
+const DefaultTraceTimeout = time.Second
+
+// Tracer finds the route to a host, the pool traces the hosts
+// going down to tell where their path breaks, see Pool.Tracer
+type Tracer interface {
+	Trace(ctx context.Context, host string) (ping.Route, error)
+}
+
+// TracerFunc adapts a function to the Tracer interface
+type TracerFunc func(ctx context.Context, host string) (ping.Route, error)
+
+// Trace calls f(ctx, host)
+func (f TracerFunc) Trace(ctx context.Context, host string) (ping.Route, error) {
+	return f(ctx, host)
+}
+
+// ICMPTracer returns a tracer sending ICMP echoes up to maxHops routers
+// away, see ping.Traceroute, from the source and with the TOS of the host
+// settings. Hosts of any scheme are traced to their host name, eg:
+// example.com for https://example.com/health.
+func ICMPTracer(opts ping.Options, maxHops int) Tracer {
+	return TracerFunc(func(ctx context.Context, host string) (ping.Route, error) {
+		return ping.Traceroute(ctx, traceHost(host), maxHops, probeOptions(ctx, opts))
+	})
+}
+
+// traceHost returns the host name or address of a host of any scheme
+func traceHost(host string) string {
+	if u, err := url.Parse(host); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Hostname()
+	}
+	return icmpHost(host)
+}
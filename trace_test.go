@@ -0,0 +1,109 @@
+package pingd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pinggg/pingd/ping"
+)
+
+// TestTracer tests the DOWN events carry the route to the host
+func TestTracer(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	seq := map[string][]bool{"h1": {false, true}}
+	for i := 0; i < 100; i++ {
+		seq["h1"] = append(seq["h1"], true)
+	}
+
+	traced := make(chan Settings, 10)
+	route := ping.Route{Hops: []ping.Hop{{TTL: 1, Addr: net.ParseIP("192.0.2.1")}, {TTL: 2}}}
+	notifyCh := make(chan HostStatus, 10)
+	var pool = &Pool{
+		Interval:  time.Millisecond,
+		FailLimit: 1,
+		Ping:      NewTestPingFunc(seq),
+		Notify:    NewTestNotifyFunc(notifyCh),
+		Tracer: TracerFunc(func(ctx context.Context, host string) (ping.Route, error) {
+			s, _ := HostSettings(ctx)
+			traced <- s
+			if host != "h1" {
+				return ping.Route{}, errors.New("no route")
+			}
+			return route, nil
+		}),
+	}
+	pool.Start()
+	defer pool.Shutdown(context.Background())
+	pool.Add(HostStatus{Host: "h1", Settings: Settings{Source: "eth1"}})
+
+	if h := <-notifyCh; !h.Down || h.Route == nil || len(h.Route.Hops) != 2 {
+		t.Errorf("Got event: %s route: %v, expected down with its route", h, h.Route)
+	}
+	if s := <-traced; s.Source != "eth1" {
+		t.Errorf("Got source: %q, expected the one of the host", s.Source)
+	}
+	if h := <-notifyCh; h.Down || h.Route != nil {
+		t.Errorf("Got event: %s route: %v, expected up without route", h, h.Route)
+	}
+
+	pool.Add(HostStatus{Host: "h2"})
+	if h := <-notifyCh; !h.Down || h.Route != nil {
+		t.Errorf("Got event: %s route: %v, expected down without route", h, h.Route)
+	}
+}
+
+var tracehosttests = []struct {
+	host, trace string
+}{
+	{"10.0.0.1", "10.0.0.1"},
+	{"2001:db8::1", "2001:db8::1"},
+	{"icmp://[2001:db8::1]", "2001:db8::1"},
+	{"https://example.com/health", "example.com"},
+	{"tcp://example.com:22", "example.com"},
+}
+
+func TestTraceHost(t *testing.T) {
+	for _, tt := range tracehosttests {
+		if host := traceHost(tt.host); host != tt.trace {
+			t.Errorf("Got host: %s for %s, expected: %s", host, tt.host, tt.trace)
+		}
+	}
+}
+
+// TestTraceTimeout tests a traceroute which doesn't end doesn't hold
+// the DOWN event longer than the trace timeout
+func TestTraceTimeout(t *testing.T) {
+	var sl SkipLog
+	log.SetOutput(sl)
+
+	notifyCh := make(chan HostStatus, 10)
+	var pool = &Pool{
+		Interval:     time.Millisecond,
+		FailLimit:    1,
+		Ping:         NewTestPingFunc(map[string][]bool{"h1": {false, false, false}}),
+		Notify:       NewTestNotifyFunc(notifyCh),
+		TraceTimeout: 10 * time.Millisecond,
+		Tracer: TracerFunc(func(ctx context.Context, host string) (ping.Route, error) {
+			<-ctx.Done()
+			return ping.Route{}, ctx.Err()
+		}),
+	}
+	pool.Start()
+	defer pool.Shutdown(context.Background())
+	pool.Add(HostStatus{Host: "h1"})
+
+	select {
+	case h := <-notifyCh:
+		if !h.Down || h.Route != nil {
+			t.Errorf("Got event: %s route: %v, expected down without route", h, h.Route)
+		}
+	case <-time.After(time.Second):
+		t.Error("Got no event, expected the trace to time out")
+	}
+}